@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Alfa-Zeta XY5 framing bytes. Data bytes only use the low 7 bits, so they
+// never look like either.
+const (
+	alfaZetaStart     = 0x80
+	alfaZetaEnd       = 0x8F
+	alfaZetaRefresh   = 0x82
+	alfaZetaBroadcast = 0xFF
+)
+
+// alfaZetaCommand describes an Alfa-Zeta command carrying pixel data.
+type alfaZetaCommand struct {
+	length  int  // data bytes that follow the address
+	refresh bool // show the data at once rather than on the next refresh
+}
+
+var alfaZetaCommands = map[byte]alfaZetaCommand{
+	0x81: {length: 112},
+	0x83: {length: 28, refresh: true},
+	0x84: {length: 28},
+	0x85: {length: 56, refresh: true},
+	0x86: {length: 56},
+}
+
+// alfaZetaDecoder decodes the Alfa-Zeta XY5 protocol: 0x80, a command, the
+// address as a byte (0xFF for every panel), one byte per column of seven
+// dots with bit 0 at the top, and 0x8F. Commands that do not refresh leave
+// their data waiting for the refresh command 0x82. Data for more columns
+// than the panel has continues on the next band of seven rows, as on
+// stacked panels.
+type alfaZetaDecoder struct {
+	pending [][]bool
+}
+
+func (d *alfaZetaDecoder) name() string { return protocolAlfaZeta }
+
+// validAlfaZetaAddress reports whether address can be set on an Alfa-Zeta
+// panel: any byte but the broadcast address.
+func validAlfaZetaAddress(address int) bool {
+	return address >= 0 && address < alfaZetaBroadcast
+}
+
+func (d *alfaZetaDecoder) split(buf []byte) ([][]byte, []byte) {
+	var packets [][]byte
+	for len(buf) > 0 {
+		start := bytes.IndexByte(buf, alfaZetaStart)
+		if start == -1 {
+			return packets, nil
+		}
+		buf = buf[start:]
+
+		end := bytes.IndexByte(buf, alfaZetaEnd)
+		if end == -1 {
+			return packets, buf
+		}
+		// A start byte before the end means the previous packet was cut
+		// short; drop it and start again from the new one.
+		if restart := bytes.IndexByte(buf[1:end], alfaZetaStart); restart != -1 {
+			buf = buf[restart+1:]
+			continue
+		}
+		packets = append(packets, buf[:end+1])
+		buf = buf[end+1:]
+	}
+	return packets, buf
+}
+
+func (d *alfaZetaDecoder) decode(data []byte) ([][]bool, bool, error) {
+	if len(data) < 4 {
+		return nil, true, rejectPacket(rejectTooShort, "Alfa-Zeta packet too short: %d bytes", len(data))
+	}
+	if data[0] != alfaZetaStart || data[len(data)-1] != alfaZetaEnd {
+		return nil, true, rejectPacket(rejectFraming, "Invalid Alfa-Zeta start or end byte: start=0x%02X, end=0x%02X", data[0], data[len(data)-1])
+	}
+	panels, err := alfaZetaPanels(data[2])
+	if err != nil {
+		return nil, true, err
+	}
+
+	if data[1] == alfaZetaRefresh {
+		frame := d.pending
+		d.pending = nil
+		return frame, true, nil
+	}
+	command, ok := alfaZetaCommands[data[1]]
+	if !ok {
+		return nil, true, rejectPacket(rejectCommand, "Unknown Alfa-Zeta command 0x%02X", data[1])
+	}
+	columns := data[3 : len(data)-1]
+	if len(columns) != command.length {
+		return nil, true, rejectPacket(rejectLength, "Alfa-Zeta command 0x%02X carries %d data bytes, got %d", data[1], command.length, len(columns))
+	}
+	for _, bits := range columns {
+		if bits > 0x7F {
+			return nil, true, rejectPacket(rejectFraming, "Invalid Alfa-Zeta data byte 0x%02X", bits)
+		}
+	}
+
+	frame := d.pending
+	if frame == nil {
+		frame = snapshotDisplay()
+	}
+	for _, panel := range panels {
+		local := panel.extract(frame)
+		for i, bits := range columns {
+			col, band := i, 0
+			if panel.Columns > 0 {
+				col, band = i%panel.Columns, i/panel.Columns
+			}
+			for bit := 0; bit < 7; bit++ {
+				row := band*7 + bit
+				if row < len(local) && col < len(local[row]) {
+					local[row][col] = bits&(1<<uint(bit)) != 0
+				}
+			}
+		}
+		panel.place(frame, local)
+	}
+
+	if !command.refresh {
+		d.pending = frame
+		return nil, true, nil
+	}
+	d.pending = nil
+	return frame, true, nil
+}
+
+// alfaZetaPanels returns the Alfa-Zeta panels a packet for address is for:
+// the one at that address, or all of them for the broadcast address.
+func alfaZetaPanels(address byte) ([]PanelConfig, error) {
+	if address == alfaZetaBroadcast {
+		return protocolPanels(protocolAlfaZeta), nil
+	}
+	if panel, ok := panelFor(protocolAlfaZeta, int(address)); ok {
+		return []PanelConfig{panel}, nil
+	}
+	cfg := currentConfig()
+	if len(cfg.Panels) > 0 {
+		return nil, rejectPacket(rejectWrongAddress, "Message not for any panel of this display. Got: %d", address)
+	}
+	return nil, rejectPacket(rejectWrongAddress, "Message not for this display. Expected: %d, Got: %d", cfg.Address, address)
+}
+
+func (d *alfaZetaDecoder) fields(data []byte) (*decodedPacket, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	if data[0] != alfaZetaStart || data[len(data)-1] != alfaZetaEnd {
+		return nil, fmt.Errorf("invalid start or end byte: start=0x%02X, end=0x%02X", data[0], data[len(data)-1])
+	}
+	return &decodedPacket{
+		Protocol:  protocolAlfaZeta,
+		Command:   data[1],
+		Address:   int(data[2]),
+		PixelData: data[3 : len(data)-1],
+	}, nil
+}
+
+func (d *alfaZetaDecoder) describe(data []byte) string {
+	if len(data) < 4 || data[0] != alfaZetaStart || data[len(data)-1] != alfaZetaEnd {
+		return "invalid Alfa-Zeta packet"
+	}
+	address := fmt.Sprintf("address %d", data[2])
+	if data[2] == alfaZetaBroadcast {
+		address = "all panels"
+	}
+	if data[1] == alfaZetaRefresh {
+		return fmt.Sprintf("refresh  %s", address)
+	}
+	return fmt.Sprintf("command 0x%02X  %s  %d columns", data[1], address, len(data)-4)
+}
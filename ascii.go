@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ASCII art draws a frame with a line per row, '#' for a set dot and '.' for
+// an unset one. Blank lines around the art are ignored, and short rows are
+// padded with unset dots, so trailing dots can be left off.
+const (
+	asciiSet   = '#'
+	asciiUnset = '.'
+)
+
+// frameToASCII draws frame as ASCII art.
+func frameToASCII(frame [][]bool) string {
+	var b strings.Builder
+	for _, row := range frame {
+		for _, on := range row {
+			if on {
+				b.WriteByte(asciiSet)
+			} else {
+				b.WriteByte(asciiUnset)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// asciiToFrame reads ASCII art into a frame as tall as it has rows and as
+// wide as its longest row.
+func asciiToFrame(art string) ([][]bool, error) {
+	art = strings.Trim(strings.ReplaceAll(art, "\r\n", "\n"), "\n")
+	if strings.TrimSpace(art) == "" {
+		return nil, fmt.Errorf("the ASCII art is empty")
+	}
+	lines := strings.Split(art, "\n")
+	columns := 0
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+		if len(lines[i]) > columns {
+			columns = len(lines[i])
+		}
+	}
+
+	frame := newFrame(len(lines), columns)
+	for row, line := range lines {
+		for col := 0; col < len(line); col++ {
+			switch line[col] {
+			case asciiSet:
+				frame[row][col] = true
+			case asciiUnset:
+			default:
+				return nil, fmt.Errorf("row %d has %q at column %d, expected '%c' or '%c'", row+1, line[col], col+1, asciiSet, asciiUnset)
+			}
+		}
+	}
+	return frame, nil
+}
+
+// asciiToDisplayFrame reads ASCII art for a rows x columns display. Art
+// smaller than the display is drawn in its top-left corner.
+func asciiToDisplayFrame(art string, rows, columns int) ([][]bool, error) {
+	src, err := asciiToFrame(art)
+	if err != nil {
+		return nil, err
+	}
+	if len(src) > rows || len(src[0]) > columns {
+		return nil, fmt.Errorf("the ASCII art is %dx%d, larger than the %dx%d display", len(src[0]), len(src), columns, rows)
+	}
+	frame := newFrame(rows, columns)
+	blitFrame(frame, src, 0, 0)
+	return frame, nil
+}
+
+// isASCIIFile reports whether path names an ASCII art file rather than an
+// image.
+func isASCIIFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".txt" || ext == ".ascii"
+}
+
+// loadFrameFile reads a frame for a rows x columns display from ASCII art
+// (a .txt or .ascii file) or from an image, which is converted with opts.
+func loadFrameFile(path string, rows, columns int, opts ImageOptions) ([][]bool, error) {
+	if isASCIIFile(path) {
+		art, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := asciiToDisplayFrame(string(art), rows, columns)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return frame, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := decodeImage(f)
+	if err != nil {
+		return nil, err
+	}
+	return imageToFrame(img, columns, rows, opts)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// asciiFrame reads ASCII art for a test, failing the test if it is invalid.
+func asciiFrame(t *testing.T, art string) [][]bool {
+	t.Helper()
+	frame, err := asciiToFrame(art)
+	if err != nil {
+		t.Fatalf("Invalid ASCII art: %v", err)
+	}
+	return frame
+}
+
+func TestASCIIRoundTrip(t *testing.T) {
+	art := `
+#..#
+.##.
+....
+`
+	frame := asciiFrame(t, art)
+	want := newFrame(3, 4)
+	want[0][0], want[0][3], want[1][1], want[1][2] = true, true, true, true
+	if !reflect.DeepEqual(frame, want) {
+		t.Errorf("Expected %v, got %v", want, frame)
+	}
+	if got := frameToASCII(frame); got != strings.TrimPrefix(art, "\n") {
+		t.Errorf("Expected the art back, got\n%s", got)
+	}
+
+	// Trailing unset dots can be left off.
+	if frame := asciiFrame(t, "#..#\n.#\n\n...."); !reflect.DeepEqual(frame[1], []bool{false, true, false, false}) {
+		t.Errorf("Expected the short row to be padded, got %v", frame[1])
+	}
+
+	for _, bad := range []string{"", "\n\n", "#.x"} {
+		if _, err := asciiToFrame(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+
+	frame, err := asciiToDisplayFrame("##\n.#", 4, 8)
+	if err != nil || len(frame) != 4 || len(frame[0]) != 8 || !frame[1][1] || frame[1][0] {
+		t.Errorf("Expected the art in the top-left corner of the display, got %v (%v)", frame, err)
+	}
+	if _, err := asciiToDisplayFrame("#########", 4, 8); err == nil {
+		t.Error("Expected art wider than the display to be rejected")
+	}
+}
+
+func TestDisplayASCIIEndpoints(t *testing.T) {
+	withConfig(t, Config{Columns: 4, Rows: 8, Address: 1})
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/display", strings.NewReader("#...\n.#..\n..#.\n")))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"packet"`) {
+		t.Fatalf("Expected the art to be shown, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/display?format=ascii", nil))
+	want := "#...\n.#..\n..#.\n....\n....\n....\n....\n....\n"
+	if rec.Body.String() != want {
+		t.Errorf("Expected the display as ASCII art\n%s\ngot\n%s", want, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/display", strings.NewReader("#?")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid art to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BridgeConfig configures bridge mode, where everything received on the
+// simulator's serial port is also forwarded to a real sign.
+type BridgeConfig struct {
+	Port        string `yaml:"port"`
+	BaudRate    int    `yaml:"baud_rate"`
+	Address     int    `yaml:"address"`
+	FixChecksum bool   `yaml:"fix_checksum"`
+}
+
+// bridge forwards received traffic to a second port. Without rewriting the
+// bytes are passed through exactly as read; when packets are re-addressed or
+// re-checksummed only complete packets are forwarded.
+type bridge struct {
+	cfg    BridgeConfig
+	out    io.Writer
+	mu     sync.Mutex
+	closed bool
+}
+
+// activeBridge is nil unless bridge mode is enabled. The serial reader and
+// reloads use it from different goroutines, so it is only accessed through
+// currentBridge and swapBridge.
+var (
+	activeBridge *bridge
+	bridgeMutex  sync.Mutex
+)
+
+// currentBridge returns the active bridge, or nil if there is none.
+func currentBridge() *bridge {
+	bridgeMutex.Lock()
+	defer bridgeMutex.Unlock()
+	return activeBridge
+}
+
+// swapBridge makes b the active bridge and closes the one it replaces. The
+// old bridge is closed only once b is in place, so the serial reader never
+// picks up a closed bridge.
+func swapBridge(b *bridge) {
+	bridgeMutex.Lock()
+	old := activeBridge
+	activeBridge = b
+	bridgeMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+func startBridge(cfg BridgeConfig) error {
+	current := currentConfig()
+	b, err := openBridge(cfg, current.BaudRate, current.Serial)
+	if err != nil {
+		return err
+	}
+	swapBridge(b)
+	log.Infof("Bridging received data to %s", cfg.Port)
+	return nil
+}
+
+// openBridge opens the bridge's output port with the simulator's line
+// settings, at defaultBaud unless the bridge has its own baud rate.
+func openBridge(cfg BridgeConfig, defaultBaud int, line SerialLineConfig) (*bridge, error) {
+	baud := cfg.BaudRate
+	if baud == 0 {
+		baud = defaultBaud
+	}
+	port, err := openSerialPort(cfg.Port, baud, line)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bridge port: %v", err)
+	}
+	return &bridge{cfg: cfg, out: port}, nil
+}
+
+// stopBridge closes the active bridge, if any.
+func stopBridge() {
+	swapBridge(nil)
+}
+
+// close closes the bridge's output port. Data forwarded afterwards, by a
+// reader that fetched the bridge just before it was replaced, is dropped.
+func (b *bridge) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if closer, ok := b.out.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// rewrites reports whether packets are modified before being forwarded.
+func (b *bridge) rewrites() bool {
+	return b.cfg.Address != 0 || b.cfg.FixChecksum
+}
+
+// forwardRaw passes bytes straight through in transparent mode.
+func (b *bridge) forwardRaw(data []byte) {
+	if b.rewrites() {
+		return
+	}
+	b.write(data)
+}
+
+// forwardPacket forwards a complete packet when rewriting is enabled.
+func (b *bridge) forwardPacket(packet []byte) {
+	if !b.rewrites() {
+		return
+	}
+	b.write(rewritePacket(packet, b.cfg.Address, b.cfg.FixChecksum))
+}
+
+func (b *bridge) write(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if _, err := b.out.Write(data); err != nil {
+		log.Errorf("Error forwarding data to bridge port: %v", err)
+	}
+}
+
+// rewritePacket returns a copy of a Hanover packet sent to address instead of
+// its original address (unless address is 0). The checksum is recomputed if
+// the address changed or fixChecksum is set.
+func rewritePacket(packet []byte, address int, fixChecksum bool) []byte {
+	rewritten := append([]byte(nil), packet...)
+	if len(rewritten) < 6 || rewritten[len(rewritten)-3] != hanoverETX {
+		return rewritten
+	}
+	if address != 0 {
+		rewritten[2] = byte('0' + address)
+		fixChecksum = true
+	}
+	if fixChecksum {
+		checksum := fmt.Sprintf("%02X", hanoverChecksum(rewritten[:len(rewritten)-2]))
+		copy(rewritten[len(rewritten)-2:], checksum)
+	}
+	return rewritten
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRewritePacket(t *testing.T) {
+	frame := newFrame(16, 8)
+	frame[0][0] = true
+	original := hanoverPacket(t, 1, frame)
+
+	// Corrupt the checksum so fix_checksum has something to do.
+	corrupted := append([]byte(nil), original...)
+	copy(corrupted[len(corrupted)-2:], "00")
+
+	fixed := rewritePacket(corrupted, 0, true)
+	if !bytes.Equal(fixed, original) {
+		t.Errorf("Expected checksum to be repaired: got %q, want %q", fixed, original)
+	}
+	if !bytes.Equal(rewritePacket(corrupted, 0, false), corrupted) {
+		t.Error("Expected packet to pass through unchanged")
+	}
+
+	readdressed := rewritePacket(original, 3, false)
+	if !bytes.Equal(readdressed, hanoverPacket(t, 3, frame)) {
+		t.Errorf("Expected packet for address 3, got %q", readdressed)
+	}
+	if readdressed[2] != '3' || original[2] != '1' {
+		t.Error("Expected rewriting to leave the original packet untouched")
+	}
+}
+
+func TestBridgeForwarding(t *testing.T) {
+	packet := hanoverPacket(t, 1, newFrame(16, 8))
+
+	var transparent bytes.Buffer
+	b := &bridge{out: &transparent}
+	b.forwardRaw([]byte{0xFF})
+	b.forwardRaw(packet)
+	b.forwardPacket(packet)
+	if !bytes.Equal(transparent.Bytes(), append([]byte{0xFF}, packet...)) {
+		t.Errorf("Transparent bridge should forward raw bytes only once, got %q", transparent.Bytes())
+	}
+
+	var rewriting bytes.Buffer
+	b = &bridge{cfg: BridgeConfig{Address: 2}, out: &rewriting}
+	b.forwardRaw([]byte{0xFF})
+	b.forwardRaw(packet)
+	b.forwardPacket(packet)
+	if expected := hanoverPacket(t, 2, newFrame(16, 8)); !bytes.Equal(rewriting.Bytes(), expected) {
+		t.Errorf("Rewriting bridge should forward only rewritten packets, got %q", rewriting.Bytes())
+	}
+}
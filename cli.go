@@ -0,0 +1,813 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tarm/serial"
+	"gopkg.in/yaml.v2"
+)
+
+// command is a CLI subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "run the simulator (default)", runServe},
+		{"send", "send text, an image or a packet to a serial port", runSend},
+		{"replay", "replay a packet log to a serial port", runReplay},
+		{"decode", "pretty-print a packet given as hex", runDecode},
+		{"record", "record packets from the serial port to a log file", runRecord},
+		{"validate-config", "check a configuration file", runValidateConfig},
+		{"convert", "convert an image into a packet", runConvert},
+		{"play", "play a playlist on a sign", runPlay},
+		{"scenario", "run scripted test scenarios headless", runScenarios},
+		{"compare", "compare a running simulator's display to a golden frame", runCompare},
+	}
+}
+
+// runCLI dispatches to a subcommand. Without a subcommand, or when the first
+// argument is a flag, the simulator is served.
+func runCLI(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+	if args[0] == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hanover-simulator <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'hanover-simulator <command> -h' for the flags of a command.")
+}
+
+// configFlags are the flags shared by every command that reads the
+// configuration file. Flags that are set override the file's values.
+type configFlags struct {
+	fs        *flag.FlagSet
+	file      string
+	overrides Config
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{fs: fs}
+	fs.StringVar(&cf.file, "config", "config.yaml", "path to the configuration file, empty for defaults and environment only")
+	fs.StringVar(&cf.overrides.SerialPort, "serial-port", "", "serial port the simulator reads from")
+	fs.StringVar(&cf.overrides.SerialPortIn, "serial-port-in", "", "serial port that senders write to")
+	fs.IntVar(&cf.overrides.BaudRate, "baud", 0, "baud rate")
+	fs.StringVar(&cf.overrides.WebPort, "web-port", "", "address for the web server, e.g. :8080")
+	fs.IntVar(&cf.overrides.Columns, "columns", 0, "display width in dots")
+	fs.IntVar(&cf.overrides.Rows, "rows", 0, "display height in dots")
+	fs.IntVar(&cf.overrides.Address, "address", 0, "display address")
+	return cf
+}
+
+// load reads the configuration and installs it as the current one.
+func (cf *configFlags) load() error {
+	cfg, err := cf.read()
+	if err != nil {
+		return err
+	}
+	setConfig(cfg)
+	return nil
+}
+
+// read reads the configuration file, applies any flags that were set on top
+// of it and validates the result.
+func (cf *configFlags) read() (Config, error) {
+	cfg, err := readConfig(cf.file)
+	if err != nil {
+		return cfg, err
+	}
+	cf.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "serial-port":
+			cfg.SerialPort = cf.overrides.SerialPort
+		case "serial-port-in":
+			cfg.SerialPortIn = cf.overrides.SerialPortIn
+		case "baud":
+			cfg.BaudRate = cf.overrides.BaudRate
+		case "web-port":
+			cfg.WebPort = cf.overrides.WebPort
+		case "columns":
+			cfg.Columns = cf.overrides.Columns
+		case "rows":
+			cfg.Rows = cf.overrides.Rows
+		case "address":
+			cfg.Address = cf.overrides.Address
+		case "playlist":
+			cfg.Playlist = cf.overrides.Playlist
+		case "ui":
+			cfg.UI = cf.overrides.UI
+		case "test-packet":
+			cfg.TestPacket = cf.overrides.TestPacket
+		}
+	})
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// fail prints an error for the named command and returns exit status 1.
+func fail(name string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	return 1
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&cf.overrides.Playlist, "playlist", "", "playlist to play on the display")
+	fs.StringVar(&cf.overrides.UI, "ui", "", "user interface: web, tui, both or none (headless)")
+	fs.BoolVar(&cf.overrides.TestPacket, "test-packet", false, "send a test packet at start-up")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		log.Errorf("Error loading config: %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The terminal UI takes over the terminal, so log output has to be
+	// silenced before anything else logs.
+	var tuiHook *tuiLogHook
+	cfg := currentConfig()
+	if cfg.UI == uiTUI || cfg.UI == uiBoth {
+		tuiHook = quietForTUI()
+	}
+
+	// Initialize display
+	initializeDisplay()
+	err := initPacketLogging(cfg.PacketLog)
+	if err != nil {
+		log.Errorf("Error initializing packet logging: %v", err)
+		return 1
+	}
+	defer closePacketLogging()
+
+	if err := initHistory(cfg.History); err != nil {
+		log.Errorf("Error initializing frame history: %v", err)
+		return 1
+	}
+	defer closeHistory()
+
+	if cfg.Wear.File != "" {
+		if err := loadWear(cfg.Wear.File); err != nil {
+			log.Errorf("Error loading dot wear: %v", err)
+			return 1
+		}
+		go saveWearPeriodically(ctx, cfg.Wear)
+	}
+
+	if cfg.Bridge.Port != "" {
+		if err := startBridge(cfg.Bridge); err != nil {
+			log.Errorf("Error starting bridge: %v", err)
+			return 1
+		}
+	}
+	defer stopBridge()
+
+	reloadFlags = cf
+	go watchConfigFile(ctx, cf.file)
+	go reloadOnSignal(ctx)
+
+	if cfg.UI == uiWeb || cfg.UI == uiBoth {
+		if err := runWebServer(ctx); err != nil {
+			log.Errorf("Failed to start web server: %v", err)
+			return 1
+		}
+		defer shutdownWebServer()
+	}
+	if cfg.Metrics.Listen != "" {
+		if err := startMetricsServer(ctx, cfg.Metrics.Listen); err != nil {
+			log.Errorf("Failed to start metrics listener: %v", err)
+			return 1
+		}
+	}
+
+	// Packet processing outlives ctx so it can drain what the serial
+	// reader queued before stopping.
+	processCtx, stopProcessing := context.WithCancel(context.Background())
+	processed := make(chan struct{})
+	go func() {
+		processPackets(processCtx)
+		close(processed)
+	}()
+
+	startSerialPort(ctx)
+	if cfg.TestPacket {
+		go testSimulator()
+	}
+	startPlaylist(ctx, cfg.Playlist)
+
+	tuiDone := make(chan struct{})
+	if tuiHook != nil {
+		go func() {
+			runTUI(ctx, tuiHook, stop)
+			close(tuiDone)
+		}()
+	} else {
+		close(tuiDone)
+	}
+
+	<-ctx.Done()
+	// Give the terminal back before anything else happens.
+	<-tuiDone
+	log.Info("Shutting down")
+	stopSerialReader()
+	stopProcessing()
+	<-processed
+	if cfg.Wear.File != "" {
+		if err := saveWear(cfg.Wear.File); err != nil {
+			log.Error(err)
+		}
+	}
+	if cfg.UI == uiNone && cfg.Metrics.Listen == "" {
+		// Nothing served the metrics, so leave a summary in the output.
+		log.Info(metrics.summary())
+	}
+	return 0
+}
+
+// openOutputPort opens the port a command writes to, defaulting to the
+// configured serial_port_in.
+func openOutputPort(name string) (*serial.Port, error) {
+	cfg := currentConfig()
+	if name == "" {
+		name = cfg.SerialPortIn
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no output port: set -to or serial_port_in")
+	}
+	port, err := openSerialPort(name, cfg.BaudRate, cfg.Serial)
+	if err != nil {
+		return nil, fmt.Errorf("error opening serial port %s: %v", name, err)
+	}
+	return port, nil
+}
+
+func runSend(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	to := fs.String("to", "", "serial port to write to (defaults to serial_port_in)")
+	text := fs.String("text", "", "text to show")
+	font := fs.String("font", defaultFont, "font for -text")
+	imageFile := fs.String("image", "", "image or ASCII art (.txt) file to show")
+	dither := fs.String("dither", ditherThreshold, "dither mode for -image")
+	threshold := fs.Int("threshold", defaultThreshold, "brightness cut-off for -image")
+	invert := fs.Bool("invert", false, "invert -image")
+	packetHex := fs.String("packet", "", "raw packet as hex")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	sources := 0
+	for _, s := range []string{*text, *imageFile, *packetHex} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		fmt.Fprintln(os.Stderr, "send: exactly one of -text, -image or -packet is required")
+		fs.Usage()
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("send", err)
+	}
+	cfg := currentConfig()
+
+	var packet []byte
+	switch {
+	case *packetHex != "":
+		var err error
+		if packet, err = parseHex(*packetHex); err != nil {
+			return fail("send", err)
+		}
+	case *text != "":
+		f, err := lookupFont(*font)
+		if err != nil {
+			return fail("send", err)
+		}
+		frames, err := textEffectFrames(*text, f, effectStatic, cfg.Columns, cfg.Rows, 0)
+		if err != nil {
+			return fail("send", err)
+		}
+		packets, err := encodeSignPackets(frames[0])
+		if err != nil {
+			return fail("send", err)
+		}
+		packet = bytes.Join(packets, nil)
+	case *imageFile != "":
+		frame, err := loadFrameFile(*imageFile, cfg.Rows, cfg.Columns, ImageOptions{
+			Dither: *dither, Threshold: *threshold, Invert: *invert,
+		})
+		if err != nil {
+			return fail("send", err)
+		}
+		packets, err := encodeSignPackets(frame)
+		if err != nil {
+			return fail("send", err)
+		}
+		packet = bytes.Join(packets, nil)
+	}
+
+	port, err := openOutputPort(*to)
+	if err != nil {
+		return fail("send", err)
+	}
+	defer port.Close()
+	if _, err := port.Write(packet); err != nil {
+		return fail("send", err)
+	}
+	return 0
+}
+
+// parseHex decodes hex that may contain whitespace between bytes.
+func parseHex(s string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %v", err)
+	}
+	return data, nil
+}
+
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	to := fs.String("to", "", "serial port to write to (defaults to serial_port_in)")
+	logPath := fs.String("log", "packet_log.json", "packet log to replay")
+	speed := fs.Float64("speed", 1, "playback speed multiplier, 0 for no delays")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("replay", err)
+	}
+
+	f, err := os.Open(*logPath)
+	if err != nil {
+		return fail("replay", err)
+	}
+	defer f.Close()
+
+	port, err := openOutputPort(*to)
+	if err != nil {
+		return fail("replay", err)
+	}
+	defer port.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var previous time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var packet Packet
+		if err := json.Unmarshal(scanner.Bytes(), &packet); err != nil {
+			return fail("replay", fmt.Errorf("invalid log entry: %v", err))
+		}
+		if !previous.IsZero() && *speed > 0 {
+			delay := time.Duration(float64(packet.Timestamp.Sub(previous)) / *speed)
+			if err := sleepContext(ctx, delay); err != nil {
+				return 1
+			}
+		}
+		previous = packet.Timestamp
+		if _, err := port.Write(packet.Data); err != nil {
+			return fail("replay", err)
+		}
+		fmt.Printf("%s  %d bytes\n", packet.Timestamp.Format(time.RFC3339Nano), len(packet.Data))
+	}
+	if err := scanner.Err(); err != nil {
+		return fail("replay", err)
+	}
+	return 0
+}
+
+func runDecode(args []string) int {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	input := strings.Join(fs.Args(), "")
+	if input == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fail("decode", err)
+		}
+		input = string(data)
+	}
+	packet, err := parseHex(input)
+	if err != nil {
+		return fail("decode", err)
+	}
+	// The configuration only picks the protocol and sets the display up
+	// for the preview, so a missing configuration file is not fatal.
+	if err := cf.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "decode: %v\n", err)
+	}
+	log.SetLevel(logrus.WarnLevel)
+	cfg := currentConfig()
+	if protocol, _ := lookupProtocol(cfg.Protocol); protocol == protocolAuto {
+		if guess, _ := detectProtocol(packet); protocols[guess.protocol] != nil {
+			cfg.Protocol = guess.protocol
+			setConfig(cfg)
+		}
+	}
+
+	decoder := activeDecoder()
+	decoded, err := decoder.fields(packet)
+	if err != nil {
+		return fail("decode", err)
+	}
+	fmt.Printf("Protocol:   %s\n", decoded.Protocol)
+	if decoded.Command < 0x80 {
+		fmt.Printf("Command:    0x%02X (%q)\n", decoded.Command, decoded.Command)
+	} else {
+		fmt.Printf("Command:    0x%02X\n", decoded.Command)
+	}
+	switch decoded.Protocol {
+	case protocolHanover:
+		fmt.Printf("Address:    %d\n", decoded.Address)
+		fmt.Printf("Resolution: 0x%02X (%d)\n", decoded.Resolution, decoded.Resolution)
+		fmt.Printf("Pixel data: %d bytes\n", len(decoded.PixelData)/2)
+	case protocolAlfaZeta:
+		fmt.Printf("Address:    %d\n", decoded.Address)
+		fmt.Printf("Pixel data: %d columns\n", len(decoded.PixelData))
+	case protocolIBIS:
+		fmt.Printf("Telegram:   %q\n", decoded.Text)
+	}
+	if decoded.HasChecksum {
+		checksumStatus := "ok"
+		if !decoded.ChecksumOK() {
+			checksumStatus = fmt.Sprintf("mismatch, expected %02X", decoded.ExpectedChecksum)
+		}
+		fmt.Printf("Checksum:   %02X (%s)\n", decoded.Checksum, checksumStatus)
+	}
+
+	if cfg.Rows > 0 && cfg.Columns > 0 {
+		initializeDisplay()
+		frame, _, err := decoder.decode(packet)
+		switch {
+		case err != nil:
+			fmt.Printf("\nNot shown: %v\n", err)
+		case frame == nil:
+			fmt.Printf("\nNothing shown yet\n")
+		default:
+			fmt.Printf("\nFrame (%dx%d):\n", cfg.Columns, cfg.Rows)
+			printFramePreview(os.Stdout, frame)
+		}
+	}
+	return 0
+}
+
+func runRecord(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	out := fs.String("out", "packet_log.json", "file to append recorded packets to")
+	format := fs.String("format", logFormatJSON, "log format: json (for replay), ndjson, hex or raw")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("record", err)
+	}
+	if _, err := formatLogEntry(*format, Packet{}); err != nil {
+		return fail("record", err)
+	}
+
+	var err error
+	logFile, err = os.OpenFile(*out, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fail("record", err)
+	}
+	logConfig = PacketLogConfig{Format: *format}
+	defer closePacketLogging()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startSerialPort(ctx)
+	defer stopSerialReader()
+	log.Infof("Recording packets from %s to %s", currentConfig().SerialPort, *out)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case packet := <-packetChan:
+			if err := logPacketToFile(packet); err != nil {
+				return fail("record", err)
+			}
+			fmt.Printf("%s  %d bytes\n", packet.Timestamp.Format(time.RFC3339Nano), len(packet.Data))
+		}
+	}
+}
+
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("validate-config", err)
+	}
+	out, err := yaml.Marshal(currentConfig())
+	if err != nil {
+		return fail("validate-config", err)
+	}
+	fmt.Printf("%s is valid. Effective configuration:\n\n%s", cf.file, out)
+	return 0
+}
+
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	in := fs.String("in", "", "input image (PNG, JPEG or GIF) or ASCII art (.txt)")
+	out := fs.String("out", "-", "output file for the packet, - for stdout")
+	format := fs.String("format", "bin", "output format: bin, hex or ascii (the frame as ASCII art)")
+	dither := fs.String("dither", ditherThreshold, "threshold, floyd-steinberg, ordered or atkinson")
+	threshold := fs.Int("threshold", defaultThreshold, "brightness cut-off (0-255)")
+	invert := fs.Bool("invert", false, "light dots for dark pixels")
+	stretch := fs.Bool("stretch", false, "ignore the aspect ratio and fill the display")
+	preview := fs.Bool("preview", false, "print a preview of the frame to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "convert: -in is required")
+		fs.Usage()
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("convert", err)
+	}
+
+	cfg := currentConfig()
+	frame, err := loadFrameFile(*in, cfg.Rows, cfg.Columns, ImageOptions{
+		Dither:    *dither,
+		Threshold: *threshold,
+		Invert:    *invert,
+		Stretch:   *stretch,
+	})
+	if err != nil {
+		return fail("convert", err)
+	}
+
+	if *preview {
+		printFramePreview(os.Stderr, frame)
+	}
+
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		return fail("convert", err)
+	}
+	var output []byte
+	switch *format {
+	case "bin":
+		output = bytes.Join(packets, nil)
+	case "hex":
+		for _, packet := range packets {
+			output = append(output, fmt.Sprintf("%X\n", packet)...)
+		}
+	case "ascii":
+		output = []byte(frameToASCII(frame))
+	default:
+		fmt.Fprintf(os.Stderr, "convert: unknown format %q\n", *format)
+		return 2
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(output)
+	} else {
+		err = os.WriteFile(*out, output, 0644)
+	}
+	if err != nil {
+		return fail("convert", err)
+	}
+	return 0
+}
+
+func runPlay(args []string) int {
+	fs := flag.NewFlagSet("play", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	playlistFile := fs.String("playlist", "", "playlist file to play")
+	to := fs.String("to", "", "serial port connected to the sign (defaults to serial_port_in)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *playlistFile == "" {
+		fmt.Fprintln(os.Stderr, "play: -playlist is required")
+		fs.Usage()
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("play", err)
+	}
+
+	playlist, err := loadPlaylist(*playlistFile)
+	if err != nil {
+		return fail("play", err)
+	}
+	out, err := openOutputPort(*to)
+	if err != nil {
+		return fail("play", err)
+	}
+	defer out.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	sink := packetSink{w: out}
+	cfg := currentConfig()
+	if err := runSequencer(ctx, playlist, sink, cfg.Columns, cfg.Rows); err != nil && err != context.Canceled {
+		return fail("play", err)
+	}
+	return 0
+}
+
+// runScenarios runs each scenario file in turn. It exits with status 1 if
+// any check failed and 2 if a scenario could not be run at all.
+func runScenarios(args []string) int {
+	fs := flag.NewFlagSet("scenario", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	update := fs.Bool("update", false, "write the expected golden images from the display instead of checking them")
+	verbose := fs.Bool("v", false, "log packet processing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "scenario: no scenario files given")
+		fs.Usage()
+		return 2
+	}
+	base, err := cf.read()
+	if err != nil {
+		return fail("scenario", err)
+	}
+	if !*verbose {
+		log.SetLevel(logrus.WarnLevel)
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		s, err := loadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "scenario: %v\n", err)
+			return 2
+		}
+		cfg, err := s.apply(base)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "scenario: %s: %v\n", path, err)
+			return 2
+		}
+		setConfig(cfg)
+		if err := runScenario(s, filepath.Dir(path), *update, os.Stdout); err != nil {
+			fmt.Printf("FAIL: %v\n", err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// runCompare compares the display of a running simulator, or a frame read
+// from a file, to a golden frame. It exits with status 1 if they differ by
+// more than the tolerance.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	from := fs.String("from", "", "URL of the simulator to compare (defaults to localhost on web_port)")
+	frameFile := fs.String("frame", "", "compare this PNG or ASCII art frame instead of a simulator's display")
+	tolerance := fs.Int("tolerance", 0, "number of dots allowed to differ")
+	diffFile := fs.String("diff", "", "write an image of the difference to this PNG file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *tolerance < 0 {
+		fmt.Fprintln(os.Stderr, "compare: give one golden frame and a non-negative -tolerance")
+		fs.Usage()
+		return 2
+	}
+	if err := cf.load(); err != nil {
+		return fail("compare", err)
+	}
+	cfg := currentConfig()
+	want, err := loadGolden(fs.Arg(0), cfg.Rows, cfg.Columns)
+	if err != nil {
+		return fail("compare", err)
+	}
+
+	var got [][]bool
+	if *frameFile != "" {
+		got, err = loadGolden(*frameFile, cfg.Rows, cfg.Columns)
+	} else {
+		got, err = fetchDisplay(*from)
+	}
+	if err != nil {
+		return fail("compare", err)
+	}
+
+	result := compareFrames(got, want, *tolerance)
+	if *diffFile != "" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, diffImage(got, want, goldenScale)); err != nil {
+			return fail("compare", err)
+		}
+		if err := os.WriteFile(*diffFile, buf.Bytes(), 0644); err != nil {
+			return fail("compare", err)
+		}
+	}
+	if result.Differing == 0 {
+		fmt.Println("match: the frames are identical")
+		return 0
+	}
+	b := result.Bounds
+	verdict := "match"
+	if !result.Match {
+		verdict = "MISMATCH"
+	}
+	fmt.Printf("%s: %d dots differ (%d extra, %d missing, tolerance %d) in the %dx%d area at column %d, row %d\n",
+		verdict, result.Differing, result.Extra, result.Missing, result.Tolerance, b.Width, b.Height, b.X, b.Y)
+	if !result.Match {
+		return 1
+	}
+	return 0
+}
+
+// fetchDisplay reads the display of the simulator at url, or at localhost on
+// the configured web port if url is empty.
+func fetchDisplay(url string) ([][]bool, error) {
+	cfg := currentConfig()
+	if url == "" {
+		host, port, err := net.SplitHostPort(cfg.WebPort)
+		if err != nil {
+			return nil, fmt.Errorf("web_port %q: %v", cfg.WebPort, err)
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		url = "http://" + net.JoinHostPort(host, port)
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/display.png?scale=1")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	frame, err := readGolden(resp.Body, cfg.Rows, cfg.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("display of %s: %v", url, err)
+	}
+	return frame, nil
+}
+
+// printFramePreview draws frame as ASCII art.
+func printFramePreview(w io.Writer, frame [][]bool) {
+	io.WriteString(w, frameToASCII(frame))
+}
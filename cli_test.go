@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFlagsOverride(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte("columns: 96\nrows: 16\naddress: 1\nbaud_rate: 4800\nweb_port: \":8080\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", filename, "-columns", "28", "-address", "2", "-web-port", ":9090"}); err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, Config{})
+	if err := cf.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	if config.Columns != 28 || config.Address != 2 || config.WebPort != ":9090" {
+		t.Errorf("Expected flags to override the file, got %+v", config)
+	}
+	if config.Rows != 16 || config.BaudRate != 4800 {
+		t.Errorf("Expected unset flags to keep the file's values, got %+v", config)
+	}
+}
+
+func TestRunCLIUnknownCommand(t *testing.T) {
+	if status := runCLI([]string{"frobnicate"}); status != 2 {
+		t.Errorf("Expected exit status 2 for an unknown command, got %d", status)
+	}
+}
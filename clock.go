@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// clock is the simulator's source of time: packet timestamps, reassembly
+// timeouts, replay and animation timing, and periodic work such as client
+// updates all read it. Tests install a virtualClock to advance time
+// deterministically.
+type clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker that ticks every d, dropping ticks for
+	// slow receivers like time.Ticker.
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the part of time.Ticker the simulator uses.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// simClock is the clock in use.
+var simClock clock = realClock{}
+
+// sleep waits for d on the simulator's clock.
+func sleep(d time.Duration) {
+	if d > 0 {
+		<-simClock.After(d)
+	}
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// virtualClock only moves when it is advanced, firing the timers and
+// tickers that come due in order.
+type virtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*virtualWaiter
+	added   chan struct{} // signalled whenever a waiter is added
+}
+
+// virtualWaiter is a pending After or a ticker. Tickers have a period.
+type virtualWaiter struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	clock  *virtualClock
+}
+
+func newVirtualClock(start time.Time) *virtualClock {
+	return &virtualClock{now: start, added: make(chan struct{}, 1)}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+func (c *virtualClock) NewTicker(d time.Duration) ticker {
+	if d <= 0 {
+		panic("non-positive interval for virtualClock.NewTicker")
+	}
+	return c.add(d, d)
+}
+
+func (c *virtualClock) add(d, period time.Duration) *virtualWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &virtualWaiter{at: c.now.Add(d), period: period, c: make(chan time.Time, 1), clock: c}
+	if d <= 0 && period == 0 {
+		w.c <- c.now
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+	return w
+}
+
+func (w *virtualWaiter) C() <-chan time.Time { return w.c }
+
+func (w *virtualWaiter) Stop() {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	w.clock.remove(w)
+}
+
+func (c *virtualClock) remove(w *virtualWaiter) {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing everything that comes due
+// on the way at the time it is due.
+func (c *virtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.c <- w.at:
+		default: // a ticker whose last tick has not been received
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil waits until at least n timers or tickers are waiting on the
+// clock, so a test can advance it once the code under test is waiting.
+func (c *virtualClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting := len(c.waiters)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-c.added
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// useVirtualClock installs a virtual clock for the rest of the test.
+func useVirtualClock(t *testing.T) *virtualClock {
+	t.Helper()
+	saved := simClock
+	clock := newVirtualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	simClock = clock
+	t.Cleanup(func() { simClock = saved })
+	return clock
+}
+
+// received reports whether c has a value waiting.
+func received(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	clock := newVirtualClock(time.Unix(0, 0))
+	start := clock.Now()
+
+	short, long := clock.After(time.Second), clock.After(3*time.Second)
+	clock.Advance(999 * time.Millisecond)
+	if received(short) {
+		t.Error("Expected nothing to fire before it is due")
+	}
+	clock.Advance(time.Millisecond)
+	if !received(short) || received(long) {
+		t.Error("Expected only the one second timer to fire")
+	}
+	clock.Advance(5 * time.Second)
+	select {
+	case at := <-long:
+		if want := start.Add(3 * time.Second); !at.Equal(want) {
+			t.Errorf("Expected the timer to fire at %v, got %v", want, at)
+		}
+	default:
+		t.Error("Expected the three second timer to fire")
+	}
+	if got := clock.Now().Sub(start); got != 6*time.Second {
+		t.Errorf("Expected the clock to have moved 6s, got %v", got)
+	}
+
+	// Tickers drop ticks nobody received, like time.Ticker.
+	tick := clock.NewTicker(100 * time.Millisecond)
+	clock.Advance(time.Second)
+	if !received(tick.C()) || received(tick.C()) {
+		t.Error("Expected a single tick to be waiting")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !received(tick.C()) {
+		t.Error("Expected the ticker to keep ticking")
+	}
+	tick.Stop()
+	clock.Advance(time.Second)
+	if received(tick.C()) {
+		t.Error("Expected no ticks after Stop")
+	}
+}
+
+func TestSequencerFrameTiming(t *testing.T) {
+	clock := useVirtualClock(t)
+	start := clock.Now()
+	playlist := &Playlist{FPS: 10, Items: []PlaylistItem{{Text: "HI", Effect: "typewriter", Hold: time.Second}}}
+
+	var shown []time.Duration
+	sink := sinkFunc(func(frame [][]bool) error {
+		shown = append(shown, clock.Now().Sub(start))
+		return nil
+	})
+	done := make(chan error)
+	go func() { done <- runSequencer(context.Background(), playlist, sink, 96, 16) }()
+
+	// Frames are 100ms apart at 10 fps, and the last is held for a second.
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(100 * time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("runSequencer failed: %v", err)
+	}
+
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}
+	if len(shown) != len(want) {
+		t.Fatalf("Expected frames at %v, got %v", want, shown)
+	}
+	for i := range want {
+		if shown[i] != want[i] {
+			t.Errorf("Expected frame %d at %v, got %v", i, want[i], shown[i])
+		}
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 1300*time.Millisecond {
+		t.Errorf("Expected the playlist to take 1.3s, took %v", elapsed)
+	}
+}
+
+type sinkFunc func(frame [][]bool) error
+
+func (f sinkFunc) showFrame(frame [][]bool) error { return f(frame) }
+
+func TestReassemblyTimeout(t *testing.T) {
+	cfg := Config{Columns: 8, Rows: 8, Address: 1, Serial: defaultSerialLine()}
+	withConfig(t, cfg)
+	clock := useVirtualClock(t)
+	saved := metrics
+	metrics = newSimulatorMetrics()
+	t.Cleanup(func() {
+		metrics = saved
+		resetReassembly()
+	})
+	resetReassembly()
+
+	frame := newFrame(8, 8)
+	frame[0][0] = true
+	packet := hanoverPacket(t, 1, frame)
+	r := newReceiver()
+	var delivered []Packet
+	deliver := func(p Packet) bool {
+		delivered = append(delivered, p)
+		return true
+	}
+
+	// A packet arriving in pieces within the timeout is reassembled.
+	r.receive(packet[:5], deliver)
+	clock.Advance(500 * time.Millisecond)
+	r.receive(packet[5:], deliver)
+	if len(delivered) != 1 || string(delivered[0].Data) != string(packet) {
+		t.Fatalf("Expected the packet to be reassembled, got %v", delivered)
+	}
+	if !delivered[0].Timestamp.Equal(clock.Now()) {
+		t.Errorf("Expected the packet to be stamped %v, got %v", clock.Now(), delivered[0].Timestamp)
+	}
+
+	// The start of a packet that is never finished is dropped once the line
+	// has been quiet for the timeout, rather than merging with the next one.
+	delivered = nil
+	r.receive(packet[:5], deliver)
+	clock.Advance(time.Second)
+	r.receive(packet, deliver)
+	if len(delivered) != 1 || string(delivered[0].Data) != string(packet) {
+		t.Fatalf("Expected only the complete packet, got %v", delivered)
+	}
+	if n := metrics.packetsRejected[rejectIncomplete]; n != 1 {
+		t.Errorf("Expected 1 incomplete packet to be counted, got %d", n)
+	}
+}
@@ -3,6 +3,13 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -12,22 +19,244 @@ type Config struct {
 	Rows       int    `yaml:"rows"`
 	Address    int    `yaml:"address"`
 	SerialPort string `yaml:"serial_port"`
-	BaudRate   int    `yaml:"baud_rate"`
-	WebPort    string `yaml:"web_port"`
+	// SerialPortIn is the sending end of the port pair: where send, play
+	// and replay write to, and what the example scripts use.
+	SerialPortIn string `yaml:"serial_port_in"`
+	BaudRate     int    `yaml:"baud_rate"`
+	WebPort      string `yaml:"web_port"`
+	Playlist     string `yaml:"playlist"`
+	// Protocol is the serial protocol the display speaks.
+	Protocol string `yaml:"protocol"`
+	// Panels builds the display from several addressed modules. When it is
+	// empty the display is a single module at Address.
+	Panels []PanelConfig `yaml:"panels"`
+	// UI selects the user interface: the web UI, the terminal UI, both or
+	// none.
+	UI string `yaml:"ui"`
+	// TestPacket sends a test packet through the parser at start-up.
+	TestPacket bool `yaml:"test_packet"`
+	// RemoteConfig lets clients on other hosts change the configuration
+	// with POST /config. By default only localhost can.
+	RemoteConfig bool `yaml:"remote_config"`
+
+	Serial    SerialLineConfig `yaml:"serial"`
+	PacketLog PacketLogConfig  `yaml:"packet_log"`
+	History   HistoryConfig    `yaml:"history"`
+	Wear      WearConfig       `yaml:"wear"`
+	Power     PowerConfig      `yaml:"power"`
+	Bridge    BridgeConfig     `yaml:"bridge"`
+	Response  ResponseConfig   `yaml:"response"`
+	Metrics   MetricsConfig    `yaml:"metrics"`
+}
+
+// config is the configuration in use. Hot reloads replace it while the
+// serial reader, decoders and web handlers are running, so it is read with
+// currentConfig and replaced with setConfig.
+var (
+	config   Config
+	configMu sync.RWMutex
+)
+
+// currentConfig returns a copy of the configuration in use. Callers that
+// read several settings should take one copy, so a reload part way through
+// cannot mix old and new values.
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
 }
 
-var config Config
+// setConfig installs cfg as the configuration in use.
+func setConfig(cfg Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = cfg
+}
 
+// envPrefix is prepended to the upper-cased YAML key path to form the name
+// of the environment variable overriding a setting, e.g. HANOVER_SERIAL_PORT
+// or HANOVER_BRIDGE_PORT.
+const envPrefix = "HANOVER"
+
+// defaultConfig returns the configuration used for any key not set in the
+// configuration file.
+func defaultConfig() Config {
+	return Config{
+		Columns:   96,
+		Rows:      16,
+		Address:   1,
+		BaudRate:  4800,
+		WebPort:   ":8080",
+		Protocol:  protocolHanover,
+		UI:        uiWeb,
+		Serial:    defaultSerialLine(),
+		PacketLog: defaultPacketLog(),
+		History:   defaultHistory(),
+		Wear:      defaultWear(),
+		Power:     defaultPower(),
+		Response:  defaultResponse(),
+	}
+}
+
+// loadConfig reads, validates and installs the configuration from filename.
 func loadConfig(filename string) error {
-	data, err := ioutil.ReadFile(filename)
+	cfg, err := readConfig(filename)
 	if err != nil {
-		return fmt.Errorf("error reading config file: %v", err)
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
 	}
+	setConfig(cfg)
+	return nil
+}
+
+// readConfig builds a configuration from the defaults, the file and the
+// environment, in increasing order of precedence. Unknown keys in the file
+// are an error. An empty filename skips the file.
+func readConfig(filename string) (Config, error) {
+	cfg := defaultConfig()
+
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %v", err)
+		}
+
+		err = yaml.UnmarshalStrict(data, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("error parsing config file: %v", err)
+		}
+	}
+
+	if err := applyEnvOverrides(reflect.ValueOf(&cfg).Elem(), envPrefix, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnvOverrides walks the struct v, setting each field whose environment
+// variable is present. Nested structs extend the variable name with their own
+// key.
+func applyEnvOverrides(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
 
-	err = yaml.Unmarshal(data, &config)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(field, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses s into field according to the field's type.
+func setFromString(field reflect.Value, s string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// validate checks the configuration for values the simulator cannot work
+// with, reporting every problem found rather than just the first.
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Columns > 0, "columns must be positive, got %d", c.Columns)
+	check(c.Rows > 0, "rows must be positive, got %d", c.Rows)
+	check(c.BaudRate > 0, "baud_rate must be positive, got %d", c.BaudRate)
+	if _, _, err := net.SplitHostPort(c.WebPort); err != nil {
+		problems = append(problems, fmt.Sprintf("web_port must be host:port or :port, got %q", c.WebPort))
+	}
+	protocol, err := lookupProtocol(c.Protocol)
 	if err != nil {
-		return fmt.Errorf("error parsing config file: %v", err)
+		problems = append(problems, err.Error())
+	}
+	if c.Columns > 0 && c.Rows > 0 {
+		problems = validatePanels(c.Panels, c.Columns, c.Rows, protocol, problems)
 	}
+	if protocol == protocolAlfaZeta {
+		check(validAlfaZetaAddress(c.Address), "address must be between 0 and 254 for the alfazeta protocol, got %d", c.Address)
+	} else {
+		check(validHanoverAddress(c.Address), "address must be between 1 and 9, got %d", c.Address)
+	}
+	hanoverOnly := protocol == protocolHanover
+	for _, p := range c.Panels {
+		if name, _ := lookupProtocol(p.Protocol); p.Protocol != "" && name != protocolHanover {
+			hanoverOnly = false
+		}
+	}
+	check(hanoverOnly || (c.Bridge.Address == 0 && !c.Bridge.FixChecksum),
+		"bridge.address and bridge.fix_checksum only work with the hanover protocol")
+	check(c.UI == uiWeb || c.UI == uiTUI || c.UI == uiBoth || c.UI == uiNone, "ui must be web, tui, both or none, got %q", c.UI)
+	problems = c.Serial.validate(problems)
+	problems = c.PacketLog.validate(problems)
+	problems = c.History.validate(problems)
+	problems = c.Wear.validate(problems)
+	problems = c.Power.validate(problems)
+	problems = c.Response.validate(problems)
+	problems = c.Metrics.validate(problems)
 
+	if c.Bridge.Port != "" {
+		check(c.Bridge.Port != c.SerialPort, "bridge.port must differ from serial_port")
+		check(c.Bridge.BaudRate >= 0, "bridge.baud_rate must not be negative, got %d", c.Bridge.BaudRate)
+		check(c.Bridge.Address == 0 || validHanoverAddress(c.Bridge.Address), "bridge.address must be between 1 and 9, or 0 to keep the address, got %d", c.Bridge.Address)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	cfg, err := readConfig(writeConfigFile(t, "serial_port: /dev/ttyUSB0\n"))
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+	expected := defaultConfig()
+	expected.SerialPort = "/dev/ttyUSB0"
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Expected defaults for unset keys, got %+v", cfg)
+	}
+}
+
+func TestReadConfigUnknownKey(t *testing.T) {
+	_, err := readConfig(writeConfigFile(t, "columns: 96\ncolums: 28\n"))
+	if err == nil || !strings.Contains(err.Error(), "colums") {
+		t.Errorf("Expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Rows = 0
+	cfg.Address = 12
+	cfg.WebPort = "8080"
+	cfg.Protocol = "morse"
+	err := cfg.validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, key := range []string{"rows", "address", "web_port", "protocol"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected the error to mention %s, got: %v", key, err)
+		}
+	}
+
+	if err := defaultConfig().validate(); err != nil {
+		t.Errorf("Expected the default configuration to be valid, got %v", err)
+	}
+
+	// protocol.md gives addresses as '1' to '9'.
+	cfg = defaultConfig()
+	cfg.Address = 0
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "address") {
+		t.Errorf("Expected address 0 to be rejected, got %v", err)
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	t.Setenv("HANOVER_SERIAL_PORT", "/dev/ttyS1")
+	t.Setenv("HANOVER_ROWS", "7")
+	t.Setenv("HANOVER_BRIDGE_FIX_CHECKSUM", "true")
+
+	cfg, err := readConfig(writeConfigFile(t, "serial_port: /dev/ttyUSB0\nrows: 16\n"))
+	if err != nil {
+		t.Fatalf("readConfig failed: %v", err)
+	}
+	if cfg.SerialPort != "/dev/ttyS1" || cfg.Rows != 7 || !cfg.Bridge.FixChecksum {
+		t.Errorf("Expected environment to override the file, got %+v", cfg)
+	}
+
+	t.Setenv("HANOVER_COLUMNS", "wide")
+	if _, err := readConfig(""); err == nil || !strings.Contains(err.Error(), "HANOVER_COLUMNS") {
+		t.Errorf("Expected an error naming the bad variable, got %v", err)
+	}
+}
+
+// withConfig installs cfg and a fresh display for the duration of a test,
+// restoring the previous configuration and display afterwards.
+func withConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	savedConfig, savedPixels := config, display.pixels
+	t.Cleanup(func() {
+		display.mu.Lock()
+		defer display.mu.Unlock()
+		setConfig(savedConfig)
+		display.pixels = savedPixels
+	})
+	setConfig(cfg)
+	initializeDisplay()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// protocolAuto selects the decoder from what the incoming data looks like.
+const protocolAuto = "auto"
+
+// protocolHanoverBinary is Hanover framing around binary rather than ASCII
+// hex pixel data. It is recognised so it can be reported, but the simulator
+// has no decoder for it.
+const protocolHanoverBinary = "hanover-binary"
+
+const (
+	// detectWindow is how many bytes are sampled for each detection.
+	detectWindow = 2048
+	// detectMinPackets is how many valid packets a guess needs before
+	// protocol: auto acts on it.
+	detectMinPackets = 2
+	// detectConfidence is the confidence above which protocol: auto
+	// switches to the detected protocol.
+	detectConfidence = 0.8
+)
+
+// ProtocolStatus reports the protocol in use and what the incoming data
+// looks like.
+type ProtocolStatus struct {
+	Configured string `json:"configured"`
+	Active     string `json:"active"`
+	// Detected is the best guess at the protocol of the received data,
+	// with Confidence the share of the sampled bytes (0 to 1) that formed
+	// valid packets of it.
+	Detected   string  `json:"detected,omitempty"`
+	Confidence float64 `json:"confidence"`
+	Packets    int     `json:"packets"`
+	// Note describes anything suspicious about the data, such as symptoms
+	// of a baud rate mismatch.
+	Note string `json:"note,omitempty"`
+}
+
+// protocolGuess is how well a sample of data fits one protocol.
+type protocolGuess struct {
+	protocol   string
+	confidence float64
+	packets    int
+}
+
+// protocolScorers check a sample against each protocol, returning the
+// number of valid packets in it, the bytes they cover and the bytes that
+// were considered: everything from the first packet start up to any
+// incomplete packet at the end.
+var protocolScorers = map[string]func(sample []byte) (packets, valid, considered int){
+	protocolHanover:       scoreHanover,
+	protocolHanoverBinary: scoreHanoverBinary,
+	protocolAlfaZeta:      scoreAlfaZeta,
+	protocolIBIS:          scoreIBIS,
+}
+
+func scoreHanover(sample []byte) (int, int, int) {
+	return scoreFramed(sample, hanoverSTX, hanoverDecoder{}.split, func(packet []byte) bool {
+		decoded, err := decodeHanoverPacket(packet)
+		return err == nil && decoded.ChecksumOK() && isHex(decoded.PixelData)
+	})
+}
+
+func scoreHanoverBinary(sample []byte) (int, int, int) {
+	return scoreFramed(sample, hanoverSTX, hanoverDecoder{}.split, func(packet []byte) bool {
+		if len(packet) < 8 || packet[2] < '0' || packet[2] > '9' || !isHex(packet[3:5]) {
+			return false
+		}
+		return !isHex(packet[5 : len(packet)-3])
+	})
+}
+
+func scoreAlfaZeta(sample []byte) (int, int, int) {
+	return scoreFramed(sample, alfaZetaStart, (&alfaZetaDecoder{}).split, func(packet []byte) bool {
+		if packet[1] == alfaZetaRefresh {
+			return len(packet) == 4
+		}
+		command, ok := alfaZetaCommands[packet[1]]
+		return ok && len(packet) == command.length+4
+	})
+}
+
+func scoreIBIS(sample []byte) (int, int, int) {
+	packets, rest := (&ibisDecoder{}).split(sample)
+	count, valid := 0, 0
+	for _, packet := range packets {
+		telegram := packet[:len(packet)-2]
+		if len(telegram) == 0 || ibisParity(packet[:len(packet)-1]) != packet[len(packet)-1]&0x7F {
+			continue
+		}
+		printable := true
+		for _, b := range telegram {
+			if b&0x7F < 0x20 || b&0x7F == 0x7F {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			count++
+			valid += len(packet)
+		}
+	}
+	return count, valid, len(sample) - len(rest)
+}
+
+// scoreFramed scores protocols whose packets begin with a start byte.
+func scoreFramed(sample []byte, start byte, split func([]byte) ([][]byte, []byte), ok func([]byte) bool) (int, int, int) {
+	first := bytes.IndexByte(sample, start)
+	if first == -1 {
+		return 0, 0, len(sample)
+	}
+	packets, rest := split(sample[first:])
+	count, valid := 0, 0
+	for _, packet := range packets {
+		if ok(packet) {
+			count++
+			valid += len(packet)
+		}
+	}
+	return count, valid, len(sample) - first - len(rest)
+}
+
+func isHex(data []byte) bool {
+	for _, b := range data {
+		if !('0' <= b && b <= '9' || 'A' <= b && b <= 'F' || 'a' <= b && b <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// detectProtocol guesses the protocol of sample. The note describes
+// symptoms of a line settings mismatch when nothing fits.
+func detectProtocol(sample []byte) (best protocolGuess, note string) {
+	for _, name := range []string{protocolHanover, protocolHanoverBinary, protocolAlfaZeta, protocolIBIS} {
+		packets, valid, considered := protocolScorers[name](sample)
+		if packets == 0 || considered == 0 {
+			continue
+		}
+		guess := protocolGuess{protocol: name, confidence: float64(valid) / float64(considered), packets: packets}
+		if guess.confidence > best.confidence {
+			best = guess
+		}
+	}
+
+	switch {
+	case best.protocol == protocolHanoverBinary:
+		note = "Hanover framing with binary pixel data, which the simulator cannot decode"
+	case best.confidence < 0.5 && len(sample) >= 64:
+		nonASCII := 0
+		for _, b := range sample {
+			if b > 0x7F {
+				nonASCII++
+			}
+		}
+		note = "no known protocol in the data"
+		if nonASCII*4 > len(sample) {
+			note += fmt.Sprintf(" and %d%% of it is outside ASCII, which suggests a baud rate or framing mismatch", nonASCII*100/len(sample))
+		}
+	}
+	return best, note
+}
+
+// protocolDetector samples the received data in windows of detectWindow
+// bytes, reporting its guess and, with protocol: auto, selecting the
+// decoder.
+type protocolDetector struct {
+	sample []byte
+}
+
+var (
+	detection      ProtocolStatus
+	autoProtocol   string
+	detectionMutex sync.Mutex
+)
+
+// observe adds data to the sample and updates the guess.
+func (d *protocolDetector) observe(data []byte) {
+	for len(data) > 0 {
+		n := detectWindow - len(d.sample)
+		if n > len(data) {
+			n = len(data)
+		}
+		d.sample = append(d.sample, data[:n]...)
+		data = data[n:]
+
+		full := len(d.sample) >= detectWindow
+		guess, note := detectProtocol(d.sample)
+		// Wait for a few packets or a full window before replacing the
+		// previous window's guess.
+		if full || guess.packets >= detectMinPackets {
+			setDetection(guess, note)
+		}
+		if full {
+			d.sample = d.sample[:0]
+		}
+	}
+}
+
+// setDetection records a guess and, with protocol: auto, switches to the
+// guessed protocol once it is confident enough.
+func setDetection(guess protocolGuess, note string) {
+	detectionMutex.Lock()
+	detection.Detected = guess.protocol
+	detection.Confidence = guess.confidence
+	detection.Packets = guess.packets
+	detection.Note = note
+	switchTo := ""
+	if configured, _ := lookupProtocol(currentConfig().Protocol); configured == protocolAuto && guess.protocol != autoProtocol && guess.confidence >= detectConfidence &&
+		guess.packets >= detectMinPackets && protocols[guess.protocol] != nil {
+		autoProtocol = guess.protocol
+		switchTo = guess.protocol
+	}
+	detectionMutex.Unlock()
+
+	if switchTo != "" {
+		log.Infof("Detected the %s protocol (%.0f%% confidence), decoding with it", switchTo, guess.confidence*100)
+		resetReassembly()
+	}
+}
+
+// detectedProtocol returns the protocol protocol: auto has selected, Hanover
+// until one is detected.
+func detectedProtocol() string {
+	detectionMutex.Lock()
+	defer detectionMutex.Unlock()
+	if autoProtocol == "" {
+		return protocolHanover
+	}
+	return autoProtocol
+}
+
+// getProtocolStatus returns the configured and active protocols and the
+// latest guess.
+func getProtocolStatus() ProtocolStatus {
+	active := activeDecoder().name()
+	detectionMutex.Lock()
+	defer detectionMutex.Unlock()
+	status := detection
+	status.Configured = currentConfig().Protocol
+	status.Active = active
+	return status
+}
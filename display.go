@@ -3,206 +3,133 @@ package main
 import (
 	"strconv"
 	"sync"
-	"fmt"
 )
 
 type HanoverDisplay struct {
 	pixels [][]bool
-	mu     sync.Mutex
+	// flips counts how often each dot has changed state.
+	flips [][]uint64
+	mu    sync.Mutex
 }
 
 var display HanoverDisplay
 
 func initializeDisplay() {
+	cfg := currentConfig()
 	display = HanoverDisplay{
-		pixels: make([][]bool, config.Rows),
+		pixels: make([][]bool, cfg.Rows),
 	}
 	for i := range display.pixels {
-		display.pixels[i] = make([]bool, config.Columns)
+		display.pixels[i] = make([]bool, cfg.Columns)
 	}
 }
 
-// original code
-// func updateDisplay(pixelData []byte) int {
-//     display.mu.Lock()
-//     defer display.mu.Unlock()
-
-//     updatedPixels := 0
-//     byteIndex := 0
-
-//     for col := 0; col < config.Columns; col++ {
-//         for rowByte := 0; rowByte < (config.Rows + 7) / 8; rowByte++ {
-//             if byteIndex+1 >= len(pixelData) {
-//                 return updatedPixels
-//             }
-//             byteVal, err := strconv.ParseUint(string(pixelData[byteIndex:byteIndex+2]), 16, 8)
-//             if err != nil {
-//                 log.Warnf("Error parsing pixel data at position %d: %v", byteIndex, err)
-//                 byteIndex += 2
-//                 continue
-//             }
-//             for bit := 0; bit < 8; bit++ {
-//                 row := rowByte*8 + bit
-//                 if row < config.Rows {
-//                     newValue := (byte(byteVal) & (1 << uint(7-bit))) != 0
-//                     if display.pixels[row][col] != newValue {
-//                         display.pixels[row][col] = newValue
-//                         updatedPixels++
-//                     }
-//                 }
-//             }
-//             byteIndex += 2
-//         }
-//     }
-
-//     return updatedPixels
-// }
-
-//try two
-// func updateDisplay(pixelData []byte) int {
-//     display.mu.Lock()
-//     defer display.mu.Unlock()
-//     updatedPixels := 0
-//     byteIndex := 0
-//     for col := 0; col < config.Columns; col++ {
-//         for rowByte := 0; rowByte < (config.Rows + 7) / 8; rowByte++ {
-//             if byteIndex+1 >= len(pixelData) {
-//                 return updatedPixels
-//             }
-//             byteVal, err := strconv.ParseUint(string(pixelData[byteIndex:byteIndex+2]), 16, 8)
-//             if err != nil {
-//                 log.Printf("Error parsing pixel data at position %d: %v", byteIndex, err)
-//                 byteIndex += 2
-//                 continue
-//             }
-//             for bit := 0; bit < 8; bit++ {
-//                 row := rowByte*8 + bit
-//                 if row < config.Rows {
-//                     newValue := (byte(byteVal) & (1 << uint(bit))) != 0
-//                     if display.pixels[row][col] != newValue {
-//                         display.pixels[row][col] = newValue
-//                         updatedPixels++
-//                     }
-//                 }
-//             }
-//             byteIndex += 2
-//         }
-//     }
-//     return updatedPixels
-// }
-
-//try three
-// func updateDisplay(pixelData []byte) int {
-//     display.mu.Lock()
-//     defer display.mu.Unlock()
-//     updatedPixels := 0
-//     byteIndex := 0
-//     for col := 0; col < config.Columns; col++ {
-//         for rowByte := 0; rowByte < (config.Rows + 7) / 8; rowByte++ {
-//             if byteIndex+1 >= len(pixelData) {
-//                 return updatedPixels
-//             }
-//             byteVal, err := strconv.ParseUint(string(pixelData[byteIndex:byteIndex+2]), 16, 8)
-//             if err != nil {
-//                 log.Printf("Error parsing pixel data at position %d: %v", byteIndex, err)
-//                 byteIndex += 2
-//                 continue
-//             }
-//             for bit := 0; bit < 8; bit++ {
-//                 row := rowByte*8 + bit
-//                 if row < config.Rows {
-//                     newValue := (byte(byteVal) & (1 << uint(7-bit))) != 0
-//                     if display.pixels[row][col] != newValue {
-//                         display.pixels[row][col] = newValue
-//                         updatedPixels++
-//                     }
-//                 }
-//             }
-//             byteIndex += 2
-//         }
-//     }
-//     return updatedPixels
-// }
-
-// try four
-// func updateDisplay(pixelData []byte) int {
-//     display.mu.Lock()
-//     defer display.mu.Unlock()
-//     updatedPixels := 0
-//     byteIndex := 0
-
-//     fmt.Printf("Updating display with pixel data of length: %d\n", len(pixelData))
-
-//     for col := 0; col < config.Columns; col++ {
-//         for rowByte := 0; rowByte < (config.Rows + 7) / 8; rowByte++ {
-//             if byteIndex+1 >= len(pixelData) {
-//                 fmt.Printf("Reached end of pixel data at byteIndex: %d\n", byteIndex)
-//                 return updatedPixels
-//             }
-//             byteVal, err := strconv.ParseUint(string(pixelData[byteIndex:byteIndex+2]), 16, 8)
-//             if err != nil {
-//                 fmt.Printf("Error parsing pixel data at position %d: %v\n", byteIndex, err)
-//                 byteIndex += 2
-//                 continue
-//             }
-//             fmt.Printf("Processing column %d, rowByte %d, byteVal: %02X\n", col, rowByte, byteVal)
-//             for bit := 0; bit < 8; bit++ {
-//                 row := rowByte*8 + bit
-//                 if row < config.Rows {
-//                     newValue := (byte(byteVal) & (1 << uint(7-bit))) != 0
-//                     if display.pixels[row][col] != newValue {
-//                         display.pixels[row][col] = newValue
-//                         updatedPixels++
-//                         fmt.Printf("Updated pixel at row %d, col %d to %v\n", row, col, newValue)
-//                     }
-//                 }
-//             }
-//             byteIndex += 2
-//         }
-//     }
-//     fmt.Printf("Total updated pixels: %d\n", updatedPixels)
-//     return updatedPixels
-// }
-
-
+// updateDisplay shows Hanover pixel data on the display. Returns the number
+// of pixels that changed state.
 func updateDisplay(pixelData []byte) int {
-    display.mu.Lock()
-    defer display.mu.Unlock()
-    updatedPixels := 0
+    frame := snapshotDisplay()
+    overlayPixelData(frame, pixelData)
+    return applyFrame(frame)
+}
+
+// overlayPixelData writes Hanover pixel data (ASCII hex, column by column)
+// into frame. Pixels past the end of the data, or in bytes that are not
+// valid hex, keep their current state.
+func overlayPixelData(frame [][]bool, pixelData []byte) {
+    rows := len(frame)
+    columns := 0
+    if rows > 0 {
+        columns = len(frame[0])
+    }
     byteIndex := 0
 
-    fmt.Printf("Initializing display update. Pixel data length: %d\n", len(pixelData))
-    fmt.Printf("Display dimensions: Rows=%d, Columns=%d\n", config.Rows, config.Columns)
+    log.Debugf("Initializing display update. Pixel data length: %d", len(pixelData))
+    log.Debugf("Display dimensions: Rows=%d, Columns=%d", rows, columns)
 
-    for col := 0; col < config.Columns; col++ {
-        for rowByte := 0; rowByte < (config.Rows+7)/8; rowByte++ {
+    for col := 0; col < columns; col++ {
+        for rowByte := 0; rowByte < (rows+7)/8; rowByte++ {
             if byteIndex+1 >= len(pixelData) {
-                fmt.Printf("Reached end of pixel data at byteIndex: %d\n", byteIndex)
-                return updatedPixels
+                log.Debugf("Reached end of pixel data at byteIndex: %d", byteIndex)
+                return
             }
-            fmt.Printf("Processing byteIndex: %d\n", byteIndex)
+            log.Debugf("Processing byteIndex: %d", byteIndex)
             byteVal, err := strconv.ParseUint(string(pixelData[byteIndex:byteIndex+2]), 16, 8)
             if err != nil {
-                fmt.Printf("Error parsing pixel data at byteIndex %d: %v\n", byteIndex, err)
+                log.Debugf("Error parsing pixel data at byteIndex %d: %v", byteIndex, err)
                 byteIndex += 2
                 continue
             }
-            fmt.Printf("Processing column %d, rowByte %d, byteVal: %02X\n", col, rowByte, byteVal)
+            log.Debugf("Processing column %d, rowByte %d, byteVal: %02X", col, rowByte, byteVal)
             for bit := 0; bit < 8; bit++ {
                 row := rowByte*8 + bit
-                if row < config.Rows {
-                    newValue := (byte(byteVal) & (1 << uint(7-bit))) != 0
-                    fmt.Printf("Checking pixel at row %d, col %d. Old value: %v, New value: %v\n", row, col, display.pixels[row][col], newValue)
-                    if display.pixels[row][col] != newValue {
-                        display.pixels[row][col] = newValue
-                        updatedPixels++
-                        fmt.Printf("Updated pixel at row %d, col %d to %v\n", row, col, newValue)
-                    }
+                if row < rows {
+                    frame[row][col] = (byte(byteVal) & (1 << uint(7-bit))) != 0
                 }
             }
             byteIndex += 2
         }
     }
-    fmt.Printf("Display update complete. Total updated pixels: %d\n", updatedPixels)
-    return updatedPixels
+}
+
+// applyFrame replaces the display contents with frame (indexed [row][col]).
+// Pixels outside the frame are left untouched. Returns the number of pixels
+// that changed state.
+func applyFrame(frame [][]bool) int {
+	display.mu.Lock()
+	defer display.mu.Unlock()
+
+	updatedPixels := 0
+	for row := 0; row < len(frame) && row < len(display.pixels); row++ {
+		for col := 0; col < len(frame[row]) && col < len(display.pixels[row]); col++ {
+			if display.pixels[row][col] != frame[row][col] {
+				display.pixels[row][col] = frame[row][col]
+				display.countFlip(row, col)
+				updatedPixels++
+			}
+		}
+	}
+	frameApplied(updatedPixels)
+	return updatedPixels
+}
+
+// frameApplied records a frame reaching the display that flipped the given
+// number of dots.
+func frameApplied(flipped int) {
+	metrics.frameShown(flipped)
+	recordFramePower(flipped)
+}
+
+// countFlip records a change of state of the dot at row, col. The counters
+// grow to follow the display when it is resized. Callers hold d.mu.
+func (d *HanoverDisplay) countFlip(row, col int) {
+	for len(d.flips) <= row {
+		d.flips = append(d.flips, nil)
+	}
+	if len(d.flips[row]) <= col {
+		grown := make([]uint64, len(d.pixels[row]))
+		copy(grown, d.flips[row])
+		d.flips[row] = grown
+	}
+	d.flips[row][col]++
+}
+
+// snapshotDisplay returns a copy of the display contents.
+func snapshotDisplay() [][]bool {
+	display.mu.Lock()
+	defer display.mu.Unlock()
+
+	frame := make([][]bool, len(display.pixels))
+	for row := range display.pixels {
+		frame[row] = append([]bool(nil), display.pixels[row]...)
+	}
+	return frame
+}
+
+// newFrame allocates an all-off frame of the given size.
+func newFrame(rows, columns int) [][]bool {
+	frame := make([][]bool, rows)
+	for i := range frame {
+		frame[i] = make([]bool, columns)
+	}
+	return frame
 }
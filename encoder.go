@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	hanoverSTX          = 0x02
+	hanoverETX          = 0x03
+	hanoverCommandWrite = '1'
+)
+
+// encodeHanoverPacket builds a "write image" packet for a display at address
+// from a frame indexed [row][col]. The frame is packed column by column, each
+// column split into (rows+7)/8 bytes with the most significant bit at the top,
+// matching what updateDisplay expects. The address is sent as a single
+// digit, so it must be one protocol.md allows.
+func encodeHanoverPacket(address int, frame [][]bool) ([]byte, error) {
+	if !validHanoverAddress(address) {
+		return nil, fmt.Errorf("address %d is not a Hanover address (1-9)", address)
+	}
+	rows := len(frame)
+	columns := 0
+	if rows > 0 {
+		columns = len(frame[0])
+	}
+
+	packet := []byte{hanoverSTX, hanoverCommandWrite, byte('0' + address)}
+	packet = append(packet, []byte(fmt.Sprintf("%02X", ((rows*columns)/8)&0xFF))...)
+
+	for col := 0; col < columns; col++ {
+		for rowByte := 0; rowByte < (rows+7)/8; rowByte++ {
+			var b byte
+			for bit := 0; bit < 8; bit++ {
+				row := rowByte*8 + bit
+				if row < rows && col < len(frame[row]) && frame[row][col] {
+					b |= 1 << uint(7-bit)
+				}
+			}
+			packet = append(packet, []byte(fmt.Sprintf("%02X", b))...)
+		}
+	}
+
+	packet = append(packet, hanoverETX)
+	packet = append(packet, []byte(fmt.Sprintf("%02X", hanoverChecksum(packet)))...)
+	return packet, nil
+}
+
+// validHanoverAddress reports whether address can be set on a Hanover
+// display, '1' to '9' according to protocol.md.
+func validHanoverAddress(address int) bool {
+	return address >= 1 && address <= 9
+}
+
+// encodeSignPackets splits a frame covering the whole sign into one packet
+// per Hanover panel, each in the panel's own orientation. Panels speaking
+// other protocols are left out.
+func encodeSignPackets(frame [][]bool) ([][]byte, error) {
+	var packets [][]byte
+	for _, p := range protocolPanels(protocolHanover) {
+		packet, err := encodeHanoverPacket(p.Address, p.extract(frame))
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+	}
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("the display has no Hanover panels to send to")
+	}
+	return packets, nil
+}
+
+// hanoverChecksum computes the checksum over a packet running from STX to ETX
+// inclusive, as described in protocol.md: sum every byte after STX, keep the
+// low 8 bits, invert and add one.
+func hanoverChecksum(data []byte) byte {
+	var sum byte
+	for i, b := range data {
+		if i == 0 && b == hanoverSTX {
+			continue
+		}
+		sum += b
+	}
+	return (sum ^ 0xFF) + 1
+}
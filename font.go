@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+)
+
+// bitmapFont is a fixed-width font. Each glyph is stored as one byte per
+// column, with bit 0 at the top.
+type bitmapFont struct {
+	name   string
+	width  int
+	height int
+	scale  int
+	glyphs map[rune][]byte
+}
+
+// font5x7Glyphs is the classic 5x7 LCD font covering printable ASCII.
+var font5x7Glyphs = map[rune][]byte{
+	' ': {0x00, 0x00, 0x00, 0x00, 0x00}, '!': {0x00, 0x00, 0x5F, 0x00, 0x00},
+	'"': {0x00, 0x07, 0x00, 0x07, 0x00}, '#': {0x14, 0x7F, 0x14, 0x7F, 0x14},
+	'$': {0x24, 0x2A, 0x7F, 0x2A, 0x12}, '%': {0x23, 0x13, 0x08, 0x64, 0x62},
+	'&': {0x36, 0x49, 0x56, 0x20, 0x50}, '\'': {0x00, 0x05, 0x03, 0x00, 0x00},
+	'(': {0x00, 0x1C, 0x22, 0x41, 0x00}, ')': {0x00, 0x41, 0x22, 0x1C, 0x00},
+	'*': {0x14, 0x08, 0x3E, 0x08, 0x14}, '+': {0x08, 0x08, 0x3E, 0x08, 0x08},
+	',': {0x00, 0x50, 0x30, 0x00, 0x00}, '-': {0x08, 0x08, 0x08, 0x08, 0x08},
+	'.': {0x00, 0x60, 0x60, 0x00, 0x00}, '/': {0x20, 0x10, 0x08, 0x04, 0x02},
+	'0': {0x3E, 0x51, 0x49, 0x45, 0x3E}, '1': {0x00, 0x42, 0x7F, 0x40, 0x00},
+	'2': {0x42, 0x61, 0x51, 0x49, 0x46}, '3': {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4': {0x18, 0x14, 0x12, 0x7F, 0x10}, '5': {0x27, 0x45, 0x45, 0x45, 0x39},
+	'6': {0x3C, 0x4A, 0x49, 0x49, 0x30}, '7': {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8': {0x36, 0x49, 0x49, 0x49, 0x36}, '9': {0x06, 0x49, 0x49, 0x29, 0x1E},
+	':': {0x00, 0x36, 0x36, 0x00, 0x00}, ';': {0x00, 0x56, 0x36, 0x00, 0x00},
+	'<': {0x08, 0x14, 0x22, 0x41, 0x00}, '=': {0x14, 0x14, 0x14, 0x14, 0x14},
+	'>': {0x00, 0x41, 0x22, 0x14, 0x08}, '?': {0x02, 0x01, 0x51, 0x09, 0x06},
+	'@': {0x32, 0x49, 0x79, 0x41, 0x3E}, 'A': {0x7E, 0x11, 0x11, 0x11, 0x7E},
+	'B': {0x7F, 0x49, 0x49, 0x49, 0x36}, 'C': {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D': {0x7F, 0x41, 0x41, 0x22, 0x1C}, 'E': {0x7F, 0x49, 0x49, 0x49, 0x41},
+	'F': {0x7F, 0x09, 0x09, 0x09, 0x01}, 'G': {0x3E, 0x41, 0x49, 0x49, 0x7A},
+	'H': {0x7F, 0x08, 0x08, 0x08, 0x7F}, 'I': {0x00, 0x41, 0x7F, 0x41, 0x00},
+	'J': {0x20, 0x40, 0x41, 0x3F, 0x01}, 'K': {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L': {0x7F, 0x40, 0x40, 0x40, 0x40}, 'M': {0x7F, 0x02, 0x0C, 0x02, 0x7F},
+	'N': {0x7F, 0x04, 0x08, 0x10, 0x7F}, 'O': {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P': {0x7F, 0x09, 0x09, 0x09, 0x06}, 'Q': {0x3E, 0x41, 0x51, 0x21, 0x5E},
+	'R': {0x7F, 0x09, 0x19, 0x29, 0x46}, 'S': {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T': {0x01, 0x01, 0x7F, 0x01, 0x01}, 'U': {0x3F, 0x40, 0x40, 0x40, 0x3F},
+	'V': {0x1F, 0x20, 0x40, 0x20, 0x1F}, 'W': {0x3F, 0x40, 0x38, 0x40, 0x3F},
+	'X': {0x63, 0x14, 0x08, 0x14, 0x63}, 'Y': {0x07, 0x08, 0x70, 0x08, 0x07},
+	'Z': {0x61, 0x51, 0x49, 0x45, 0x43}, '[': {0x00, 0x7F, 0x41, 0x41, 0x00},
+	'\\': {0x02, 0x04, 0x08, 0x10, 0x20}, ']': {0x00, 0x41, 0x41, 0x7F, 0x00},
+	'^': {0x04, 0x02, 0x01, 0x02, 0x04}, '_': {0x40, 0x40, 0x40, 0x40, 0x40},
+	'`': {0x00, 0x01, 0x02, 0x04, 0x00}, 'a': {0x20, 0x54, 0x54, 0x54, 0x78},
+	'b': {0x7F, 0x48, 0x44, 0x44, 0x38}, 'c': {0x38, 0x44, 0x44, 0x44, 0x20},
+	'd': {0x38, 0x44, 0x44, 0x48, 0x7F}, 'e': {0x38, 0x54, 0x54, 0x54, 0x18},
+	'f': {0x08, 0x7E, 0x09, 0x01, 0x02}, 'g': {0x0C, 0x52, 0x52, 0x52, 0x3E},
+	'h': {0x7F, 0x08, 0x04, 0x04, 0x78}, 'i': {0x00, 0x44, 0x7D, 0x40, 0x00},
+	'j': {0x20, 0x40, 0x44, 0x3D, 0x00}, 'k': {0x7F, 0x10, 0x28, 0x44, 0x00},
+	'l': {0x00, 0x41, 0x7F, 0x40, 0x00}, 'm': {0x7C, 0x04, 0x18, 0x04, 0x78},
+	'n': {0x7C, 0x08, 0x04, 0x04, 0x78}, 'o': {0x38, 0x44, 0x44, 0x44, 0x38},
+	'p': {0x7C, 0x14, 0x14, 0x14, 0x08}, 'q': {0x08, 0x14, 0x14, 0x18, 0x7C},
+	'r': {0x7C, 0x08, 0x04, 0x04, 0x08}, 's': {0x48, 0x54, 0x54, 0x54, 0x20},
+	't': {0x04, 0x3F, 0x44, 0x40, 0x20}, 'u': {0x3C, 0x40, 0x40, 0x20, 0x7C},
+	'v': {0x1C, 0x20, 0x40, 0x20, 0x1C}, 'w': {0x3C, 0x40, 0x30, 0x40, 0x3C},
+	'x': {0x44, 0x28, 0x10, 0x28, 0x44}, 'y': {0x0C, 0x50, 0x50, 0x50, 0x3C},
+	'z': {0x44, 0x64, 0x54, 0x4C, 0x44}, '{': {0x00, 0x08, 0x36, 0x41, 0x00},
+	'|': {0x00, 0x00, 0x7F, 0x00, 0x00}, '}': {0x00, 0x41, 0x36, 0x08, 0x00},
+	'~': {0x10, 0x08, 0x08, 0x10, 0x08},
+}
+
+// fonts lists the fonts available for rendering text, keyed by name.
+var fonts = map[string]*bitmapFont{
+	"5x7":   {name: "5x7", width: 5, height: 7, scale: 1, glyphs: font5x7Glyphs},
+	"10x14": {name: "10x14", width: 5, height: 7, scale: 2, glyphs: font5x7Glyphs},
+}
+
+const defaultFont = "5x7"
+
+// lookupFont returns the named font, or the default font for an empty name.
+func lookupFont(name string) (*bitmapFont, error) {
+	if name == "" {
+		name = defaultFont
+	}
+	font, ok := fonts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown font %q", name)
+	}
+	return font, nil
+}
+
+// glyphWidth and glyphHeight are the rendered size of one character.
+func (f *bitmapFont) glyphWidth() int  { return f.width * f.scale }
+func (f *bitmapFont) glyphHeight() int { return f.height * f.scale }
+
+// textWidth returns the rendered width of text, with one (scaled) column of
+// spacing between characters.
+func (f *bitmapFont) textWidth(text string) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return n*(f.glyphWidth()+f.scale) - f.scale
+}
+
+// renderText draws text into a new frame exactly large enough to hold it.
+// Characters without a glyph are drawn as '?'.
+func (f *bitmapFont) renderText(text string) [][]bool {
+	frame := newFrame(f.glyphHeight(), f.textWidth(text))
+	x := 0
+	for _, r := range text {
+		glyph, ok := f.glyphs[r]
+		if !ok {
+			glyph = f.glyphs['?']
+		}
+		for col, bits := range glyph {
+			for row := 0; row < f.height; row++ {
+				if bits&(1<<uint(row)) == 0 {
+					continue
+				}
+				for dy := 0; dy < f.scale; dy++ {
+					for dx := 0; dx < f.scale; dx++ {
+						frame[row*f.scale+dy][x+col*f.scale+dx] = true
+					}
+				}
+			}
+		}
+		x += f.glyphWidth() + f.scale
+	}
+	return frame
+}
+
+// blitFrame copies src into dst with its top-left corner at (x, y), clipping
+// anything that falls outside dst. Only set pixels are copied.
+func blitFrame(dst, src [][]bool, x, y int) {
+	for row := range src {
+		dy := y + row
+		if dy < 0 || dy >= len(dst) {
+			continue
+		}
+		for col, on := range src[row] {
+			dx := x + col
+			if on && dx >= 0 && dx < len(dst[dy]) {
+				dst[dy][dx] = true
+			}
+		}
+	}
+}
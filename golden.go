@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// goldenScale is the size in pixels of a dot in golden images written by the
+// simulator, the same as GET /display.png.
+const goldenScale = 8
+
+// Colours of diff images.
+var (
+	diffSame    = color.RGBA{0x55, 0x55, 0x00, 0xFF} // set in both, dimmed
+	diffExtra   = color.RGBA{0xFF, 0x30, 0x30, 0xFF} // set but not expected
+	diffMissing = color.RGBA{0x30, 0xA0, 0xFF, 0xFF} // expected but not set
+)
+
+// loadGolden reads a golden frame of a rows x columns display from a PNG
+// image or an ASCII art text file.
+func loadGolden(path string, rows, columns int) ([][]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	frame, err := readGolden(f, rows, columns)
+	if err != nil {
+		return nil, fmt.Errorf("golden %s: %v", path, err)
+	}
+	return frame, nil
+}
+
+// readGolden reads a golden frame, telling PNG images from ASCII art by
+// their signature.
+func readGolden(r io.Reader, rows, columns int) ([][]bool, error) {
+	br := bufio.NewReader(r)
+	if signature, _ := br.Peek(8); string(signature) == "\x89PNG\r\n\x1a\n" {
+		return readGoldenPNG(br, rows, columns)
+	}
+	return readGoldenASCII(br, rows, columns)
+}
+
+// readGoldenPNG reads a golden image. The image can have one pixel per dot
+// or be scaled up by a whole number, as images from GET /display.png are;
+// each dot is read from the centre of its square and is set if that pixel is
+// bright.
+func readGoldenPNG(r io.Reader, rows, columns int) ([][]bool, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	scale := bounds.Dx() / columns
+	if scale < 1 || bounds.Dx() != scale*columns || bounds.Dy() != scale*rows {
+		return nil, fmt.Errorf("image is %dx%d, not a whole multiple of the %dx%d display",
+			bounds.Dx(), bounds.Dy(), columns, rows)
+	}
+
+	frame := newFrame(rows, columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			r, g, b, _ := img.At(bounds.Min.X+col*scale+scale/2, bounds.Min.Y+row*scale+scale/2).RGBA()
+			frame[row][col] = (r+g+b)/3 >= 0x8000
+		}
+	}
+	return frame, nil
+}
+
+// readGoldenASCII reads a golden frame drawn as ASCII art, which must be
+// the size of the display. Unlike other ASCII art, every row of a golden
+// must be drawn in full, so a short row is an error rather than padded.
+func readGoldenASCII(r io.Reader, rows, columns int) ([][]bool, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := asciiToFrame(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != rows || len(frame[0]) != columns {
+		return nil, fmt.Errorf("ASCII art is %dx%d, not the %dx%d display", len(frame[0]), len(frame), columns, rows)
+	}
+	lines := strings.Split(strings.Trim(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	for row, line := range lines {
+		if width := len(strings.TrimRight(line, " \t")); width != columns {
+			return nil, fmt.Errorf("row %d has %d columns, expected %d", row+1, width, columns)
+		}
+	}
+	return frame, nil
+}
+
+// saveGolden writes frame as a golden image.
+func saveGolden(path string, frame [][]bool) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, frameImage(frame, goldenScale)); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// frameDiff lists the dots, as {row, column}, where two frames of the same
+// size differ.
+func frameDiff(got, want [][]bool) [][2]int {
+	var diff [][2]int
+	for row := range want {
+		for col := range want[row] {
+			if row >= len(got) || col >= len(got[row]) || got[row][col] != want[row][col] {
+				diff = append(diff, [2]int{row, col})
+			}
+		}
+	}
+	return diff
+}
+
+// DiffBounds is the smallest rectangle holding every differing dot.
+type DiffBounds struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FrameComparison is the result of comparing a frame to a golden one. Extra
+// dots are set but should not be, missing dots should be set but are not.
+// The frames match if no more than Tolerance dots differ.
+type FrameComparison struct {
+	Match     bool        `json:"match"`
+	Differing int         `json:"differing"`
+	Extra     int         `json:"extra"`
+	Missing   int         `json:"missing"`
+	Tolerance int         `json:"tolerance"`
+	Bounds    *DiffBounds `json:"bounds,omitempty"`
+}
+
+// compareFrames compares got to the golden frame want, allowing tolerance
+// dots to differ.
+func compareFrames(got, want [][]bool, tolerance int) FrameComparison {
+	result := FrameComparison{Tolerance: tolerance}
+	diff := frameDiff(got, want)
+	for _, dot := range diff {
+		row, col := dot[0], dot[1]
+		if want[row][col] {
+			result.Missing++
+		} else {
+			result.Extra++
+		}
+		if result.Bounds == nil {
+			result.Bounds = &DiffBounds{X: col, Y: row, Width: 1, Height: 1}
+			continue
+		}
+		b := result.Bounds
+		if col < b.X {
+			b.Width += b.X - col
+			b.X = col
+		} else if col >= b.X+b.Width {
+			b.Width = col - b.X + 1
+		}
+		if row >= b.Y+b.Height {
+			b.Height = row - b.Y + 1
+		}
+	}
+	result.Differing = len(diff)
+	result.Match = result.Differing <= tolerance
+	return result
+}
+
+// diffImage draws got against the golden frame want with each dot scale
+// pixels wide: dots set in both dimmed, extra dots red and missing dots blue.
+func diffImage(got, want [][]bool, scale int) *image.RGBA {
+	img := frameImage(want, scale)
+	gap := 0
+	if scale >= 3 {
+		gap = 1
+	}
+	for row := range want {
+		for col := range want[row] {
+			on := row < len(got) && col < len(got[row]) && got[row][col]
+			var c color.RGBA
+			switch {
+			case on && want[row][col]:
+				c = diffSame
+			case on:
+				c = diffExtra
+			case want[row][col]:
+				c = diffMissing
+			default:
+				continue
+			}
+			for y := 0; y < scale-gap; y++ {
+				for x := 0; x < scale-gap; x++ {
+					img.SetRGBA(col*scale+x, row*scale+y, c)
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadGolden(t *testing.T) {
+	want := newFrame(3, 4)
+	want[0][0], want[1][2], want[2][3] = true, true, true
+
+	ascii := "#...\n..#.\n...#\n"
+	frame, err := readGolden(strings.NewReader(ascii), 3, 4)
+	if err != nil || !reflect.DeepEqual(frame, want) {
+		t.Errorf("Expected %v from ASCII art, got %v (%v)", want, frame, err)
+	}
+
+	for _, scale := range []int{1, goldenScale} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frameImage(want, scale)); err != nil {
+			t.Fatal(err)
+		}
+		frame, err := readGolden(&buf, 3, 4)
+		if err != nil || !reflect.DeepEqual(frame, want) {
+			t.Errorf("Expected %v from a PNG at scale %d, got %v (%v)", want, scale, frame, err)
+		}
+	}
+
+	for _, bad := range []string{"#...\n..#.\n", "#...\n..#.\n..#\n", "#...\n..#..\n...#\n", "#...\n..x.\n...#\n"} {
+		if _, err := readGolden(strings.NewReader(bad), 3, 4); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestCompareFrames(t *testing.T) {
+	want := newFrame(4, 6)
+	want[1][1], want[1][2] = true, true
+	got := newFrame(4, 6)
+	got[1][1], got[3][4], got[2][0] = true, true, true
+
+	result := compareFrames(got, want, 0)
+	expected := FrameComparison{Differing: 3, Extra: 2, Missing: 1, Bounds: &DiffBounds{X: 0, Y: 1, Width: 5, Height: 3}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v, got %+v (bounds %+v)", expected, result, result.Bounds)
+	}
+	if !compareFrames(got, want, 3).Match {
+		t.Error("Expected the frames to match within a tolerance of 3 dots")
+	}
+	if result := compareFrames(want, want, 0); !result.Match || result.Bounds != nil {
+		t.Errorf("Expected identical frames to match, got %+v", result)
+	}
+
+	img := diffImage(got, want, 1)
+	for _, tc := range []struct {
+		row, col int
+		want     color.RGBA
+	}{
+		{1, 1, diffSame}, {3, 4, diffExtra}, {1, 2, diffMissing}, {0, 0, imageDotOff},
+	} {
+		if c := img.RGBAAt(tc.col, tc.row); c != tc.want {
+			t.Errorf("Expected dot %d,%d of the diff image to be %v, got %v", tc.row, tc.col, tc.want, c)
+		}
+	}
+}
+
+func TestDisplayCompareEndpoint(t *testing.T) {
+	withConfig(t, Config{Columns: 4, Rows: 2, Address: 1})
+	display.pixels[0][1] = true
+
+	compare := func(query, golden string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("golden", "golden.txt")
+		part.Write([]byte(golden))
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/display/compare"+query, &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := compare("?tolerance=1", "....\n.#..\n")
+	var result FrameComparison
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected a comparison, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result.Match || result.Differing != 2 || result.Extra != 1 || result.Missing != 1 {
+		t.Errorf("Unexpected comparison %+v", result)
+	}
+
+	rec = compare("?format=png&scale=2", ".#..\n....\n")
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected a diff image, got %d: %v", rec.Code, err)
+	}
+	if size := img.Bounds().Size(); size.X != 8 || size.Y != 4 {
+		t.Errorf("Expected an 8x4 image, got %v", size)
+	}
+
+	if rec := compare("", "#\n"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a golden of the wrong size to be rejected, got %d", rec.Code)
+	}
+}
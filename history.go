@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistoryConfig bounds the frame history by number of frames, age and
+// memory used; 0 disables a limit. With Dir set the history is also written
+// to disk and survives restarts.
+type HistoryConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	MaxFrames int           `yaml:"max_frames"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxBytes  int           `yaml:"max_bytes"`
+	Dir       string        `yaml:"dir"`
+}
+
+func defaultHistory() HistoryConfig {
+	return HistoryConfig{
+		Enabled:   true,
+		MaxFrames: 10000,
+	}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c HistoryConfig) validate(problems []string) []string {
+	if c.MaxFrames < 0 || c.MaxAge < 0 || c.MaxBytes < 0 {
+		problems = append(problems, "history limits must not be negative")
+	}
+	return problems
+}
+
+// historyFile is the name of the on-disk history inside HistoryConfig.Dir.
+const historyFile = "history.ndjson"
+
+// historyEntry is one frame shown on the sign, packed one bit per pixel in
+// row-major order.
+type historyEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Rows      int       `json:"rows"`
+	Columns   int       `json:"columns"`
+	Bits      []byte    `json:"bits"`
+}
+
+// entryOverhead approximates the memory used by an entry besides its bits.
+const entryOverhead = 64
+
+func (e historyEntry) size() int {
+	return len(e.Bits) + entryOverhead
+}
+
+func packFrame(frame [][]bool) (rows, columns int, bits []byte) {
+	rows = len(frame)
+	if rows > 0 {
+		columns = len(frame[0])
+	}
+	bits = make([]byte, (rows*columns+7)/8)
+	for row := range frame {
+		for col, on := range frame[row] {
+			if on {
+				i := row*columns + col
+				bits[i/8] |= 1 << uint(7-i%8)
+			}
+		}
+	}
+	return rows, columns, bits
+}
+
+// validate reports why an entry read back from disk cannot be turned into
+// a frame: a negative size, or fewer bits than its size needs.
+func (e historyEntry) validate() error {
+	if e.Rows < 0 || e.Columns < 0 {
+		return fmt.Errorf("invalid size %dx%d", e.Columns, e.Rows)
+	}
+	// Compared by division so huge sizes cannot overflow.
+	if e.Rows > 0 && e.Columns > len(e.Bits)*8/e.Rows {
+		return fmt.Errorf("%d bytes of pixels is too few for %dx%d", len(e.Bits), e.Columns, e.Rows)
+	}
+	return nil
+}
+
+func (e historyEntry) frame() [][]bool {
+	frame := newFrame(e.Rows, e.Columns)
+	for row := range frame {
+		for col := range frame[row] {
+			i := row*e.Columns + col
+			frame[row][col] = e.Bits[i/8]&(1<<uint(7-i%8)) != 0
+		}
+	}
+	return frame
+}
+
+// historyStore holds frames in time order.
+type historyStore struct {
+	cfg     HistoryConfig
+	mu      sync.Mutex
+	entries []historyEntry
+	bytes   int
+
+	file *os.File
+	// fileEntries counts the entries written to file, including pruned
+	// ones, to decide when it is worth compacting.
+	fileEntries int
+}
+
+// frameHistory is nil when history is disabled.
+var frameHistory *historyStore
+
+// initHistory creates the frame history, loading any history saved in
+// cfg.Dir.
+func initHistory(cfg HistoryConfig) error {
+	if !cfg.Enabled {
+		log.Info("Frame history disabled")
+		return nil
+	}
+	h, err := openHistory(cfg)
+	if err != nil {
+		return err
+	}
+	frameHistory = h
+	return nil
+}
+
+// closeHistory flushes and closes the on-disk history, if any.
+func closeHistory() {
+	if frameHistory != nil {
+		frameHistory.close()
+		frameHistory = nil
+	}
+}
+
+func openHistory(cfg HistoryConfig) (*historyStore, error) {
+	h := &historyStore{cfg: cfg}
+	if cfg.Dir == "" {
+		return h, nil
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %v", err)
+	}
+	if err := h.load(filepath.Join(cfg.Dir, historyFile)); err != nil {
+		return nil, err
+	}
+	h.prune(simClock.Now())
+	// Start from a compacted file so pruned entries do not linger.
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded %d frames of history from %s", len(h.entries), cfg.Dir)
+	return h, nil
+}
+
+// load reads the entries saved in name, if it exists.
+func (h *historyStore) load(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading history: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a partial last line behind.
+			log.Warnf("Skipping invalid history entry: %v", err)
+			continue
+		}
+		if err := entry.validate(); err != nil {
+			log.Warnf("Skipping invalid history entry from %v: %v", entry.Timestamp, err)
+			continue
+		}
+		h.entries = append(h.entries, entry)
+		h.bytes += entry.size()
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the on-disk history to hold only the retained entries.
+func (h *historyStore) compact() error {
+	name := filepath.Join(h.cfg.Dir, historyFile)
+	tmp, err := os.CreateTemp(h.cfg.Dir, historyFile+".*")
+	if err != nil {
+		return fmt.Errorf("error compacting history: %v", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for _, entry := range h.entries {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			w.Write(append(data, '\n'))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error compacting history: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error compacting history: %v", err)
+	}
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	h.file, err = os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history: %v", err)
+	}
+	h.fileEntries = len(h.entries)
+	return nil
+}
+
+func (h *historyStore) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+// record adds frame to the history unless it matches the latest entry.
+func (h *historyStore) record(t time.Time, frame [][]bool) {
+	rows, columns, bits := packFrame(frame)
+	entry := historyEntry{Timestamp: t, Rows: rows, Columns: columns, Bits: bits}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n := len(h.entries); n > 0 {
+		last := h.entries[n-1]
+		if last.Rows == rows && last.Columns == columns && string(last.Bits) == string(bits) {
+			return
+		}
+	}
+	h.entries = append(h.entries, entry)
+	h.bytes += entry.size()
+
+	if h.file != nil {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			_, err = h.file.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Errorf("Error saving frame history: %v", err)
+		}
+		h.fileEntries++
+	}
+
+	h.prune(t)
+	if h.file != nil && h.fileEntries > 2*len(h.entries)+100 {
+		if err := h.compact(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// prune drops the oldest entries beyond the configured limits, always
+// keeping the latest frame.
+func (h *historyStore) prune(now time.Time) {
+	drop := 0
+	bytes := h.bytes
+	for drop < len(h.entries)-1 {
+		remaining := len(h.entries) - drop
+		entry := h.entries[drop]
+		if (h.cfg.MaxFrames > 0 && remaining > h.cfg.MaxFrames) ||
+			(h.cfg.MaxBytes > 0 && bytes > h.cfg.MaxBytes) ||
+			(h.cfg.MaxAge > 0 && now.Sub(entry.Timestamp) > h.cfg.MaxAge) {
+			bytes -= entry.size()
+			drop++
+			continue
+		}
+		break
+	}
+	// Reslicing leaves the dropped entries in the backing array until
+	// the next append reallocates it.
+	h.entries = h.entries[drop:]
+	h.bytes = bytes
+}
+
+// at returns the frame that was showing at t, the latest entry recorded no
+// later than t, and its index.
+func (h *historyStore) at(t time.Time) (historyEntry, int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.Search(len(h.entries), func(i int) bool {
+		return h.entries[i].Timestamp.After(t)
+	})
+	if i == 0 {
+		return historyEntry{}, -1, false
+	}
+	return h.entries[i-1], i - 1, true
+}
+
+// index returns the i-th entry, oldest first.
+func (h *historyStore) index(i int) (historyEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < 0 || i >= len(h.entries) {
+		return historyEntry{}, false
+	}
+	return h.entries[i], true
+}
+
+// span returns the number of entries and the times of the first and last.
+func (h *historyStore) span() (count int, first, last time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return 0, time.Time{}, time.Time{}
+	}
+	return len(h.entries), h.entries[0].Timestamp, h.entries[len(h.entries)-1].Timestamp
+}
+
+// recordDisplayHistory adds the current display contents to the history as
+// shown at the given time.
+func recordDisplayHistory(at time.Time) {
+	if frameHistory == nil {
+		return
+	}
+	frameHistory.record(at, snapshotDisplay())
+}
+
+// parseHistoryTime parses a moment to look up in the history: RFC 3339, or
+// a clock time such as 14:03 or 14:03:30 meaning the most recent such time
+// today in local time.
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		clock, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+		t := time.Date(now.Year(), now.Month(), now.Day(),
+			clock.Hour(), clock.Minute(), clock.Second(), 0, now.Location())
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or HH:MM[:SS]", s)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyFrame returns a frame with only the pixel at row, col lit.
+func historyFrame(row, col int) [][]bool {
+	frame := newFrame(16, 8)
+	frame[row][col] = true
+	return frame
+}
+
+func TestHistoryAt(t *testing.T) {
+	h, err := openHistory(HistoryConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 14, 0, 0, 0, time.UTC)
+	h.record(start, historyFrame(0, 0))
+	h.record(start.Add(time.Minute), historyFrame(0, 0)) // unchanged, not recorded
+	h.record(start.Add(3*time.Minute), historyFrame(15, 7))
+
+	if _, _, ok := h.at(start.Add(-time.Second)); ok {
+		t.Error("Expected no frame before the first one was recorded")
+	}
+	entry, index, ok := h.at(start.Add(2 * time.Minute))
+	if !ok || index != 0 || !entry.frame()[0][0] {
+		t.Errorf("Expected the first frame at 14:02, got index %d", index)
+	}
+	entry, index, ok = h.at(start.Add(time.Hour))
+	if !ok || index != 1 || !entry.frame()[15][7] || entry.frame()[0][0] {
+		t.Errorf("Expected the second frame at 15:00, got index %d", index)
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	h, err := openHistory(HistoryConfig{Enabled: true, MaxFrames: 3, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		h.record(start.Add(time.Duration(i)*time.Minute), historyFrame(i, 0))
+	}
+	if count, first, _ := h.span(); count != 3 || !first.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("Expected the 3 newest frames to be kept, got %d from %v", count, first)
+	}
+
+	h.record(start.Add(3*time.Hour), historyFrame(5, 0))
+	if count, _, _ := h.span(); count != 1 {
+		t.Errorf("Expected frames older than an hour to be dropped, got %d", count)
+	}
+}
+
+func TestHistoryPersists(t *testing.T) {
+	cfg := HistoryConfig{Enabled: true, Dir: t.TempDir()}
+	h, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	h.record(start, historyFrame(1, 1))
+	h.record(start.Add(time.Second), historyFrame(2, 2))
+	h.close()
+
+	h, err = openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	entry, index, ok := h.at(start.Add(time.Minute))
+	if !ok || index != 1 || !entry.frame()[2][2] {
+		t.Errorf("Expected the saved history to be loaded, got index %d", index)
+	}
+}
+
+func TestHistorySkipsInvalidEntries(t *testing.T) {
+	cfg := HistoryConfig{Enabled: true, Dir: t.TempDir()}
+	lines := []string{
+		`{"timestamp":"2024-01-01T12:00:00Z","rows":-1,"columns":8,"bits":""}`,
+		`{"timestamp":"2024-01-01T12:00:01Z","rows":16,"columns":8,"bits":"AAAA"}`,
+		`{"timestamp":"2024-01-01T12:00:02Z","rows":4611686018427387904,"columns":4,"bits":"AA=="}`,
+		`{"timestamp":"2024-01-01T12:00:03Z","rows":2,"columns":4,"bits":"8A=="}`,
+		`{"timestamp":"2024-01-01T12:00:04Z","rows":2`,
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Dir, historyFile), []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	if len(h.entries) != 1 {
+		t.Fatalf("Expected only the valid entry to be loaded, got %d", len(h.entries))
+	}
+	if frame := h.entries[0].frame(); !frame[0][0] || !frame[0][3] || frame[1][0] {
+		t.Errorf("Expected the valid entry's frame, got %v", frame)
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"14:03":                time.Date(2024, 1, 2, 14, 3, 0, 0, time.UTC),
+		"16:30:15":             time.Date(2024, 1, 1, 16, 30, 15, 0, time.UTC),
+		"2023-12-31T23:59:00Z": time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC),
+	}
+	for input, want := range cases {
+		got, err := parseHistoryTime(input, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseHistoryTime(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := parseHistoryTime("lunchtime", now); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+}
+
+func TestHistoryRecordsPacketTime(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	useVirtualClock(t)
+	h, err := openHistory(HistoryConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameHistory = h
+	defer func() { frameHistory = nil }()
+
+	// A packet that waited in the queue is recorded as of its arrival, not
+	// of when it was processed.
+	received := simClock.Now().Add(-time.Second)
+	handlePacket(Packet{Timestamp: received, Data: hanoverPacket(t, 1, historyFrame(3, 4))})
+	if count, first, _ := h.span(); count != 1 || !first.Equal(received) {
+		t.Errorf("Expected one frame recorded at %v, got %d at %v", received, count, first)
+	}
+}
+
+func TestHistoryFrameEndpoint(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	h, err := openHistory(HistoryConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameHistory = h
+	defer func() { frameHistory = nil }()
+	h.record(time.Now().Add(-time.Minute), historyFrame(3, 4))
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/frame?index=0", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pixels [][]bool `json:"pixels"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Pixels) != 16 || !resp.Pixels[3][4] {
+		t.Errorf("Expected the recorded frame, got %v", resp.Pixels)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/frame?index=5", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing frame, got %d", w.Code)
+	}
+}
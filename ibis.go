@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ibisEnd ends every IBIS telegram. It is followed by a parity byte.
+const ibisEnd = 0x0D
+
+// ibisDecoder decodes the IBIS (VDV 300) telegrams that drive Brose and
+// Luminator destination signs. A telegram is 7-bit ASCII ending in a carriage
+// return and a parity byte, the XOR of 0x7F and every byte before it. The
+// sign renders the text itself, so the decoder keeps the last line number
+// (l001) and destination (zA2 followed by two 16 character blocks, or a z123
+// destination code) and draws them with the built-in font: the line on the
+// left and the destination centred in the space left over. IBIS telegrams
+// are broadcast, so every IBIS panel shows them and the address is not
+// checked; telegrams for other devices are ignored.
+type ibisDecoder struct {
+	line        string
+	destination string
+}
+
+func (d *ibisDecoder) name() string { return protocolIBIS }
+
+func (d *ibisDecoder) split(buf []byte) ([][]byte, []byte) {
+	var packets [][]byte
+	for {
+		end := bytes.IndexByte(buf, ibisEnd)
+		if end == -1 || len(buf) < end+2 {
+			return packets, buf
+		}
+		packets = append(packets, buf[:end+2])
+		buf = buf[end+2:]
+	}
+}
+
+// ibisParity returns the parity byte for a telegram running up to and
+// including its carriage return.
+func ibisParity(telegram []byte) byte {
+	parity := byte(0x7F)
+	for _, b := range telegram {
+		parity ^= b & 0x7F
+	}
+	return parity
+}
+
+func (d *ibisDecoder) decode(data []byte) ([][]bool, bool, error) {
+	if len(data) < 2 || data[len(data)-2] != ibisEnd {
+		return nil, true, rejectPacket(rejectFraming, "IBIS telegram does not end in a carriage return")
+	}
+	checksumOK := ibisParity(data[:len(data)-1]) == data[len(data)-1]&0x7F
+	telegram := string(data[:len(data)-2])
+
+	switch {
+	case strings.HasPrefix(telegram, "zA") || strings.HasPrefix(telegram, "zI"):
+		text, err := ibisText(telegram[2:])
+		if err != nil {
+			return nil, checksumOK, err
+		}
+		d.destination = text
+	case len(telegram) == 4 && telegram[0] == 'z' && isDigits(telegram[1:]):
+		d.destination = trimLeadingZeros(telegram[1:])
+	case len(telegram) == 4 && telegram[0] == 'l' && isDigits(telegram[1:]):
+		d.line = trimLeadingZeros(telegram[1:])
+	default:
+		log.Infof("Ignoring IBIS telegram %q", telegram)
+		return nil, checksumOK, nil
+	}
+	return d.render(), checksumOK, nil
+}
+
+// ibisText decodes the text of a zA or zI telegram: a block count, '0' plus
+// the number of 16 character blocks, followed by the blocks.
+func ibisText(s string) (string, error) {
+	if s == "" {
+		return "", rejectPacket(rejectTooShort, "IBIS text telegram has no block count")
+	}
+	blocks := int(s[0]) - '0'
+	if blocks < 0 || blocks > 15 {
+		return "", rejectPacket(rejectLength, "Invalid IBIS block count %q", s[0])
+	}
+	text := s[1:]
+	if len(text) > blocks*16 {
+		text = text[:blocks*16]
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// render draws the line number and destination on every IBIS panel.
+func (d *ibisDecoder) render() [][]bool {
+	frame := snapshotDisplay()
+	for _, panel := range protocolPanels(protocolIBIS) {
+		panel.place(frame, d.renderPanel(panel.Rows, panel.Columns))
+	}
+	return frame
+}
+
+// renderPanel draws the line number and destination on a panel of the given
+// size.
+func (d *ibisDecoder) renderPanel(rows, columns int) [][]bool {
+	frame := newFrame(rows, columns)
+	font, _ := lookupFont(defaultFont)
+	y := (rows - font.glyphHeight()) / 2
+
+	x := 0
+	if d.line != "" {
+		blitFrame(frame, font.renderText(d.line), 0, y)
+		x = font.textWidth(d.line) + 2*font.glyphWidth()
+	}
+	if d.destination != "" {
+		width := font.textWidth(d.destination)
+		if space := columns - x; width < space {
+			x += (space - width) / 2
+		}
+		blitFrame(frame, font.renderText(d.destination), x, y)
+	}
+	return frame
+}
+
+func (d *ibisDecoder) fields(data []byte) (*decodedPacket, error) {
+	if len(data) < 3 || data[len(data)-2] != ibisEnd {
+		return nil, fmt.Errorf("telegram does not end in a carriage return")
+	}
+	return &decodedPacket{
+		Protocol:         protocolIBIS,
+		Command:          data[0],
+		Text:             string(data[:len(data)-2]),
+		HasChecksum:      true,
+		Checksum:         data[len(data)-1] & 0x7F,
+		ExpectedChecksum: ibisParity(data[:len(data)-1]),
+	}, nil
+}
+
+func (d *ibisDecoder) describe(data []byte) string {
+	if len(data) < 2 || data[len(data)-2] != ibisEnd {
+		return "invalid IBIS telegram"
+	}
+	parity := "parity ok"
+	if expected := ibisParity(data[:len(data)-1]); expected != data[len(data)-1]&0x7F {
+		parity = fmt.Sprintf("parity %02X, expected %02X", data[len(data)-1], expected)
+	}
+	return fmt.Sprintf("%q  %s", data[:len(data)-2], parity)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func trimLeadingZeros(s string) string {
+	if trimmed := strings.TrimLeft(s, "0"); trimmed != "" {
+		return trimmed
+	}
+	return "0"
+}
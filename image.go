@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Supported dithering modes for imageToFrame.
+const (
+	ditherThreshold      = "threshold"
+	ditherFloydSteinberg = "floyd-steinberg"
+	ditherOrdered        = "ordered"
+	ditherAtkinson       = "atkinson"
+)
+
+// ImageOptions controls how an image is reduced to a 1-bit frame.
+type ImageOptions struct {
+	Dither    string // one of the dither* modes, defaults to threshold
+	Threshold int    // 0-255 brightness cut-off
+	Invert    bool   // light dots for dark source pixels
+	Stretch   bool   // ignore the aspect ratio and fill the whole display
+}
+
+// defaultThreshold is the brightness cut-off used unless one is given.
+const defaultThreshold = 128
+
+// defaultImageOptions returns the options used when none are given:
+// thresholding at mid-grey.
+func defaultImageOptions() ImageOptions {
+	return ImageOptions{Dither: ditherThreshold, Threshold: defaultThreshold}
+}
+
+// bayer4x4 is the threshold map used for ordered dithering.
+var bayer4x4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// decodeImage reads a PNG, JPEG or GIF image. For animated GIFs only the
+// first frame is used.
+func decodeImage(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+	return img, nil
+}
+
+// imageToFrame scales img to columns x rows and reduces it to a frame indexed
+// [row][col] using the thresholding or dithering mode from opts.
+func imageToFrame(img image.Image, columns, rows int, opts ImageOptions) ([][]bool, error) {
+	if columns <= 0 || rows <= 0 {
+		return nil, fmt.Errorf("invalid display size %dx%d", columns, rows)
+	}
+	threshold := float64(opts.Threshold)
+
+	gray := scaleToGray(img, columns, rows, opts.Stretch)
+	if opts.Invert {
+		for row := range gray {
+			for col := range gray[row] {
+				gray[row][col] = 255 - gray[row][col]
+			}
+		}
+	}
+
+	frame := newFrame(rows, columns)
+	switch opts.Dither {
+	case "", ditherThreshold:
+		for row := range gray {
+			for col := range gray[row] {
+				frame[row][col] = gray[row][col] >= threshold
+			}
+		}
+	case ditherOrdered:
+		for row := range gray {
+			for col := range gray[row] {
+				// Shift the threshold by the Bayer cell, centred on zero.
+				offset := (bayer4x4[row%4][col%4]+0.5)/16*255 - 127.5
+				frame[row][col] = gray[row][col] >= threshold+offset
+			}
+		}
+	case ditherFloydSteinberg:
+		diffuseError(gray, frame, threshold, []errorWeight{
+			{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+		})
+	case ditherAtkinson:
+		diffuseError(gray, frame, threshold, []errorWeight{
+			{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8},
+			{0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+		})
+	default:
+		return nil, fmt.Errorf("unknown dither mode %q", opts.Dither)
+	}
+	return frame, nil
+}
+
+// errorWeight describes where a share of the quantisation error goes,
+// relative to the current pixel.
+type errorWeight struct {
+	dx, dy int
+	weight float64
+}
+
+// diffuseError quantises gray into frame, spreading each pixel's error to its
+// neighbours according to weights.
+func diffuseError(gray [][]float64, frame [][]bool, threshold float64, weights []errorWeight) {
+	rows := len(gray)
+	for row := 0; row < rows; row++ {
+		columns := len(gray[row])
+		for col := 0; col < columns; col++ {
+			old := gray[row][col]
+			var quantised float64
+			if old >= threshold {
+				frame[row][col] = true
+				quantised = 255
+			}
+			quantError := old - quantised
+			for _, w := range weights {
+				x, y := col+w.dx, row+w.dy
+				if x >= 0 && x < columns && y < rows {
+					gray[y][x] += quantError * w.weight
+				}
+			}
+		}
+	}
+}
+
+// scaleToGray box-filters img down (or up) to columns x rows, returning
+// luminance values in the range 0-255. Transparent areas count as dark, since
+// an unset dot is the sign's background. Unless stretch is set, the aspect
+// ratio is preserved and the image is centred.
+func scaleToGray(img image.Image, columns, rows int, stretch bool) [][]float64 {
+	bounds := img.Bounds()
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+
+	// Target area the image is drawn into, in display pixels.
+	dstX, dstY, dstW, dstH := 0.0, 0.0, float64(columns), float64(rows)
+	if !stretch && srcW > 0 && srcH > 0 {
+		scale := dstW / srcW
+		if dstH/srcH < scale {
+			scale = dstH / srcH
+		}
+		dstW, dstH = srcW*scale, srcH*scale
+		dstX, dstY = (float64(columns)-dstW)/2, (float64(rows)-dstH)/2
+	}
+
+	gray := make([][]float64, rows)
+	for row := 0; row < rows; row++ {
+		gray[row] = make([]float64, columns)
+		for col := 0; col < columns; col++ {
+			// Source rectangle covered by this display pixel.
+			x0 := (float64(col) - dstX) / dstW * srcW
+			x1 := (float64(col+1) - dstX) / dstW * srcW
+			y0 := (float64(row) - dstY) / dstH * srcH
+			y1 := (float64(row+1) - dstY) / dstH * srcH
+			gray[row][col] = averageLuminance(img, x0, y0, x1, y1)
+		}
+	}
+	return gray
+}
+
+// averageLuminance returns the mean luminance of the source pixels whose
+// centres fall in [x0,x1) x [y0,y1), or of the nearest pixel if none do.
+// Areas outside the image are treated as black.
+func averageLuminance(img image.Image, x0, y0, x1, y1 float64) float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if x1 <= 0 || y1 <= 0 || x0 >= float64(w) || y0 >= float64(h) {
+		return 0
+	}
+
+	ix0, ix1 := clampInt(int(x0+0.5), 0, w), clampInt(int(x1+0.5), 0, w)
+	iy0, iy1 := clampInt(int(y0+0.5), 0, h), clampInt(int(y1+0.5), 0, h)
+	if ix1 <= ix0 {
+		ix0 = clampInt(int((x0+x1)/2), 0, w-1)
+		ix1 = ix0 + 1
+	}
+	if iy1 <= iy0 {
+		iy0 = clampInt(int((y0+y1)/2), 0, h-1)
+		iy1 = iy0 + 1
+	}
+
+	var sum float64
+	for y := iy0; y < iy1; y++ {
+		for x := ix0; x < ix1; x++ {
+			// RGBA values are alpha-premultiplied, so transparent
+			// pixels already come out black.
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+	return sum / float64((ix1-ix0)*(iy1-iy0))
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// Colours used by frameImage, matching the web UI.
+var (
+	imageDotOn  = color.RGBA{0xFF, 0xFF, 0x00, 0xFF}
+	imageDotOff = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+	imageGrid   = color.RGBA{0x44, 0x44, 0x44, 0xFF}
+)
+
+// frameImage draws frame with each dot as a square scale pixels wide. From a
+// scale of 3 up the dots are separated by a one pixel grid.
+func frameImage(frame [][]bool, scale int) *image.RGBA {
+	rows := len(frame)
+	columns := 0
+	if rows > 0 {
+		columns = len(frame[0])
+	}
+	img := image.NewRGBA(image.Rect(0, 0, columns*scale, rows*scale))
+	gap := 0
+	if scale >= 3 {
+		gap = 1
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dot := imageDotOff
+			if frame[row][col] {
+				dot = imageDotOn
+			}
+			for y := 0; y < scale; y++ {
+				for x := 0; x < scale; x++ {
+					c := dot
+					if x >= scale-gap || y >= scale-gap {
+						c = imageGrid
+					}
+					img.SetRGBA(col*scale+x, row*scale+y, c)
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestImageToFrameThreshold(t *testing.T) {
+	// Left half white, right half black.
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	frame, err := imageToFrame(img, 8, 4, defaultImageOptions())
+	if err != nil {
+		t.Fatalf("imageToFrame failed: %v", err)
+	}
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 8; col++ {
+			if frame[row][col] != (col < 4) {
+				t.Errorf("Unexpected pixel at row %d, col %d: %v", row, col, frame[row][col])
+			}
+		}
+	}
+
+	inverted, err := imageToFrame(img, 8, 4, ImageOptions{Threshold: defaultThreshold, Invert: true})
+	if err != nil {
+		t.Fatalf("imageToFrame failed: %v", err)
+	}
+	if inverted[0][0] || !inverted[0][7] {
+		t.Errorf("Expected inverted frame, got %v", inverted[0])
+	}
+
+	// A threshold of 0 lights every dot, even black ones.
+	all, err := imageToFrame(img, 8, 4, ImageOptions{Threshold: 0})
+	if err != nil {
+		t.Fatalf("imageToFrame failed: %v", err)
+	}
+	if !all[0][7] {
+		t.Error("Expected a threshold of 0 to light black dots")
+	}
+}
+
+func TestImageToFrameDitherMidGray(t *testing.T) {
+	img := image.NewUniform(color.Gray{Y: 128})
+	uniform := image.NewGray(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			uniform.Set(x, y, img.C)
+		}
+	}
+
+	for _, mode := range []string{ditherFloydSteinberg, ditherOrdered, ditherAtkinson} {
+		t.Run(mode, func(t *testing.T) {
+			frame, err := imageToFrame(uniform, 16, 16, ImageOptions{Dither: mode, Threshold: defaultThreshold})
+			if err != nil {
+				t.Fatalf("imageToFrame failed: %v", err)
+			}
+			on := 0
+			for _, row := range frame {
+				for _, pixel := range row {
+					if pixel {
+						on++
+					}
+				}
+			}
+			// A 50% gray should come out roughly half lit.
+			if on < 96 || on > 160 {
+				t.Errorf("Expected about half of 256 dots lit, got %d", on)
+			}
+		})
+	}
+
+	if _, err := imageToFrame(uniform, 16, 16, ImageOptions{Dither: "bogus"}); err == nil {
+		t.Error("Expected an error for an unknown dither mode")
+	}
+}
+
+func TestDecodeImageAndEncodePacket(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+
+	src := image.NewGray(image.Rect(0, 0, 8, 16))
+	src.SetGray(0, 0, color.Gray{Y: 255})
+	src.SetGray(7, 15, color.Gray{Y: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := decodeImage(&buf)
+	if err != nil {
+		t.Fatalf("decodeImage failed: %v", err)
+	}
+	frame, err := imageToFrame(img, config.Columns, config.Rows, defaultImageOptions())
+	if err != nil {
+		t.Fatalf("imageToFrame failed: %v", err)
+	}
+
+	packet := hanoverPacket(t, config.Address, frame)
+	// STX, command, address, 2 resolution chars, 2 chars per byte, ETX, checksum
+	if expected := 1 + 1 + 1 + 2 + 8*2*2 + 1 + 2; len(packet) != expected {
+		t.Fatalf("Expected packet length %d, got %d", expected, len(packet))
+	}
+	checksum := fmt.Sprintf("%02X", hanoverChecksum(packet[:len(packet)-2]))
+	if string(packet[len(packet)-2:]) != checksum {
+		t.Errorf("Expected checksum %s in %q", checksum, packet)
+	}
+
+	parseData(packet)
+	if countUpdatedPixels() != 2 || !display.pixels[0][0] || !display.pixels[15][7] {
+		t.Errorf("Round trip through parseData produced the wrong display state")
+	}
+}
+
+// hanoverPacket encodes frame for a test, failing the test if it cannot.
+func hanoverPacket(t *testing.T, address int, frame [][]bool) []byte {
+	t.Helper()
+	packet, err := encodeHanoverPacket(address, frame)
+	if err != nil {
+		t.Fatalf("encodeHanoverPacket failed: %v", err)
+	}
+	return packet
+}
+
+func TestEncodeHanoverPacketAddress(t *testing.T) {
+	for _, address := range []int{0, 10, -1} {
+		if _, err := encodeHanoverPacket(address, newFrame(16, 8)); err == nil {
+			t.Errorf("Expected address %d to be rejected", address)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Reasons a received packet is rejected, used as the reason label of
+// hanover_packets_rejected_total.
+const (
+	rejectTooShort      = "too_short"
+	rejectFraming       = "bad_framing"
+	rejectAddressFormat = "bad_address_format"
+	rejectWrongAddress  = "wrong_address"
+	rejectResolution    = "bad_resolution"
+	rejectCommand       = "unknown_command"
+	rejectLength        = "bad_length"
+	rejectIncomplete    = "incomplete"
+)
+
+// MetricsConfig configures a listener serving only /metrics, so metrics can
+// be scraped while the simulator runs headless.
+type MetricsConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c MetricsConfig) validate(problems []string) []string {
+	if c.Listen == "" {
+		return problems
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("metrics.listen must be host:port or :port, got %q", c.Listen))
+	}
+	return problems
+}
+
+// fpsWindow is the period over which hanover_frames_per_second is averaged.
+const fpsWindow = 10 * time.Second
+
+// parseLatencyBuckets are the upper bounds, in seconds, of the parse latency
+// histogram.
+var parseLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// simulatorMetrics collects the counters exposed at /metrics. Gauges such as
+// the queue depth are read when the metrics are scraped.
+type simulatorMetrics struct {
+	mu sync.Mutex
+
+	packetsReceived uint64
+	packetsRejected map[string]uint64
+	checksumErrors  uint64
+	bytesRead       uint64
+	pixelsFlipped   uint64
+	frames          uint64
+	recentFrames    []time.Time
+
+	responses map[string]uint64
+
+	parseBuckets []uint64
+	parseSum     float64
+	parseCount   uint64
+}
+
+var metrics = newSimulatorMetrics()
+
+func newSimulatorMetrics() *simulatorMetrics {
+	return &simulatorMetrics{
+		packetsRejected: make(map[string]uint64),
+		responses:       make(map[string]uint64),
+		parseBuckets:    make([]uint64, len(parseLatencyBuckets)),
+	}
+}
+
+func (m *simulatorMetrics) packetReceived() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packetsReceived++
+}
+
+func (m *simulatorMetrics) packetRejected(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packetsRejected[reason]++
+}
+
+func (m *simulatorMetrics) checksumError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checksumErrors++
+}
+
+func (m *simulatorMetrics) read(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesRead += uint64(n)
+}
+
+// frameShown records a frame reaching the display and the pixels it flipped.
+func (m *simulatorMetrics) frameShown(flipped int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames++
+	m.pixelsFlipped += uint64(flipped)
+
+	now := simClock.Now()
+	m.recentFrames = append(m.recentFrames, now)
+	m.trimRecentFrames(now)
+}
+
+func (m *simulatorMetrics) trimRecentFrames(now time.Time) {
+	i := 0
+	for i < len(m.recentFrames) && now.Sub(m.recentFrames[i]) > fpsWindow {
+		i++
+	}
+	m.recentFrames = m.recentFrames[i:]
+}
+
+func (m *simulatorMetrics) responded(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[kind]++
+}
+
+func (m *simulatorMetrics) observeParse(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := d.Seconds()
+	for i, bound := range parseLatencyBuckets {
+		if seconds <= bound {
+			m.parseBuckets[i]++
+		}
+	}
+	m.parseSum += seconds
+	m.parseCount++
+}
+
+// writePrometheus writes all metrics in the Prometheus text exposition
+// format.
+func (m *simulatorMetrics) writePrometheus(w io.Writer) {
+	m.mu.Lock()
+	m.trimRecentFrames(simClock.Now())
+
+	writeMetric(w, "hanover_packets_received_total", "counter", "Complete packets received.", "", float64(m.packetsReceived))
+
+	writeHeader(w, "hanover_packets_rejected_total", "counter", "Packets rejected, by reason.")
+	reasons := make([]string, 0, len(m.packetsRejected))
+	for reason := range m.packetsRejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		writeSample(w, "hanover_packets_rejected_total", fmt.Sprintf(`reason=%q`, reason), float64(m.packetsRejected[reason]))
+	}
+
+	writeMetric(w, "hanover_checksum_errors_total", "counter", "Packets whose checksum did not match their contents.", "", float64(m.checksumErrors))
+	writeMetric(w, "hanover_bytes_read_total", "counter", "Bytes read from the serial port.", "", float64(m.bytesRead))
+	writeMetric(w, "hanover_frames_total", "counter", "Frames shown on the display.", "", float64(m.frames))
+	writeMetric(w, "hanover_frames_per_second", "gauge", "Frames shown per second over the last 10 seconds.", "", float64(len(m.recentFrames))/fpsWindow.Seconds())
+	writeMetric(w, "hanover_pixels_flipped_total", "counter", "Pixels that changed state.", "", float64(m.pixelsFlipped))
+
+	writeHeader(w, "hanover_responses_total", "counter", "Responses written back to the sender, by kind.")
+	kinds := make([]string, 0, len(m.responses))
+	for kind := range m.responses {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		writeSample(w, "hanover_responses_total", fmt.Sprintf(`kind=%q`, kind), float64(m.responses[kind]))
+	}
+
+	writeHeader(w, "hanover_parse_duration_seconds", "histogram", "Time taken to parse a packet and update the display.")
+	for i, bound := range parseLatencyBuckets {
+		writeSample(w, "hanover_parse_duration_seconds_bucket", fmt.Sprintf(`le="%s"`, strconv.FormatFloat(bound, 'g', -1, 64)), float64(m.parseBuckets[i]))
+	}
+	writeSample(w, "hanover_parse_duration_seconds_bucket", `le="+Inf"`, float64(m.parseCount))
+	writeSample(w, "hanover_parse_duration_seconds_sum", "", m.parseSum)
+	writeSample(w, "hanover_parse_duration_seconds_count", "", float64(m.parseCount))
+	m.mu.Unlock()
+
+	frames, overBudget := recentPower()
+	if len(frames) > 0 {
+		last := frames[len(frames)-1]
+		writeMetric(w, "hanover_frame_energy_millijoules", "gauge", "Estimated coil energy of the last frame.", "", last.Energy)
+		writeMetric(w, "hanover_frame_peak_current_amperes", "gauge", "Estimated peak coil current of the last frame.", "", last.PeakCurrent)
+	}
+	writeMetric(w, "hanover_frames_over_power_budget_total", "counter", "Frames estimated to exceed the power budget.", "", float64(overBudget))
+
+	clientsMutex.Lock()
+	sseClients := len(clients)
+	clientsMutex.Unlock()
+	writeMetric(w, "hanover_clients_connected", "gauge", "Web clients connected for live updates.", `transport="sse"`, float64(sseClients))
+
+	writeMetric(w, "hanover_packet_queue_depth", "gauge", "Packets waiting to be processed.", "", float64(len(packetChan)))
+	writeMetric(w, "hanover_packet_queue_capacity", "gauge", "Capacity of the packet queue.", "", float64(cap(packetChan)))
+
+	writeHeader(w, "hanover_dot_flips_total", "counter", "Times each dot changed state, for dots that have flipped.")
+	display.mu.Lock()
+	for row := range display.flips {
+		for col, flips := range display.flips[row] {
+			if flips > 0 {
+				writeSample(w, "hanover_dot_flips_total", fmt.Sprintf(`row="%d",column="%d"`, row, col), float64(flips))
+			}
+		}
+	}
+	display.mu.Unlock()
+}
+
+// summary describes the packet counters on one line.
+func (m *simulatorMetrics) summary() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rejected uint64
+	for _, n := range m.packetsRejected {
+		rejected += n
+	}
+	return fmt.Sprintf("Received %d packets (%d rejected, %d checksum errors), showed %d frames, read %d bytes",
+		m.packetsReceived, rejected, m.checksumErrors, m.frames, m.bytesRead)
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeMetric(w io.Writer, name, kind, help, labels string, value float64) {
+	writeHeader(w, name, kind, help)
+	writeSample(w, name, labels, value)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writePrometheus(w)
+}
+
+// startMetricsServer serves /metrics on addr until ctx is cancelled.
+func startMetricsServer(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	serveMetrics(ctx, listener)
+	return nil
+}
+
+// serveMetrics serves /metrics on listener until ctx is cancelled.
+func serveMetrics(ctx context.Context, listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics listener failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Infof("Serving metrics on %s", listener.Addr())
+}
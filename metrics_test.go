@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	old := metrics
+	metrics = newSimulatorMetrics()
+	defer func() { metrics = old }()
+
+	frame := newFrame(16, 8)
+	frame[2][3] = true
+	handlePacket(Packet{Timestamp: time.Now(), Data: hanoverPacket(t, 1, frame)})
+	handlePacket(Packet{Timestamp: time.Now(), Data: hanoverPacket(t, 2, frame)})
+	metrics.read(42)
+
+	var buf bytes.Buffer
+	metrics.writePrometheus(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE hanover_packets_received_total counter\nhanover_packets_received_total 2\n",
+		`hanover_packets_rejected_total{reason="wrong_address"} 1`,
+		"hanover_bytes_read_total 42\n",
+		"hanover_frames_total 1\n",
+		"hanover_pixels_flipped_total 1\n",
+		`hanover_dot_flips_total{row="2",column="3"} 1`,
+		`hanover_parse_duration_seconds_bucket{le="+Inf"} 2`,
+		"hanover_parse_duration_seconds_count 2\n",
+		"hanover_checksum_errors_total 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	want := "Received 2 packets (1 rejected, 0 checksum errors), showed 1 frames, read 42 bytes"
+	if got := metrics.summary(); got != want {
+		t.Errorf("Expected summary %q, got %q", want, got)
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveMetrics(ctx, listener)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "hanover_packets_received_total") {
+		t.Errorf("Expected the metrics, got %d: %s", resp.StatusCode, body)
+	}
+
+	if problems := (MetricsConfig{Listen: "9100"}).validate(nil); len(problems) != 1 {
+		t.Errorf("Expected a listen address without a port to be rejected, got %q", problems)
+	}
+}
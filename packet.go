@@ -2,8 +2,8 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
-	"os"
+	"context"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -12,79 +12,204 @@ import (
 type Packet struct {
 	Timestamp time.Time `json:"timestamp"`
 	Data      []byte    `json:"data"`
+	// FromPort is set on packets read from the serial port, the only ones
+	// the sender is waiting for a response to.
+	FromPort bool `json:"-"`
+	// flushed marks a packet sent by waitForPackets rather than received;
+	// processPackets closes it instead of handling the packet.
+	flushed chan struct{}
 }
 
 var (
 	packetLog     []Packet
+	packetLogMu   sync.Mutex
 	packetChan    = make(chan Packet, 100)
 	partialPacket []byte
-	logFile       *os.File
-	logMutex      sync.Mutex
 )
 
-func initPacketLogging() error {
-	var err error
-	logFile, err = os.OpenFile("packet_log.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+// recentPackets returns a copy of the last n packets received, oldest
+// first, or all of them if n is 0.
+func recentPackets(n int) []Packet {
+	packetLogMu.Lock()
+	defer packetLogMu.Unlock()
+	start := 0
+	if n > 0 && len(packetLog) > n {
+		start = len(packetLog) - n
 	}
-	return nil
+	return append([]Packet(nil), packetLog[start:]...)
 }
 
-func closePacketLogging() {
-	if logFile != nil {
-		logFile.Close()
+// processPackets handles packets from packetChan until ctx is cancelled, then
+// drains anything still queued before returning.
+func processPackets(ctx context.Context) {
+	log.Info("Started processing packets")
+	for {
+		select {
+		case packet := <-packetChan:
+			processPacket(packet)
+		case <-ctx.Done():
+			for {
+				select {
+				case packet := <-packetChan:
+					processPacket(packet)
+				default:
+					log.Info("Stopped processing packets")
+					return
+				}
+			}
+		}
 	}
 }
 
-func logPacketToFile(packet Packet) error {
-	logMutex.Lock()
-	defer logMutex.Unlock()
+func processPacket(packet Packet) {
+	if packet.flushed != nil {
+		close(packet.flushed)
+		return
+	}
+	handlePacket(packet)
+}
 
-	jsonPacket, err := json.Marshal(packet)
-	if err != nil {
-		return err
+// waitForPackets waits until processPackets has handled every packet
+// already sent to packetChan. processPackets must be running.
+func waitForPackets() {
+	flushed := make(chan struct{})
+	packetChan <- Packet{flushed: flushed}
+	<-flushed
+}
+
+func handlePacket(packet Packet) {
+	packetLogMu.Lock()
+	packetLog = append(packetLog, packet)
+	if len(packetLog) > 100 {
+		packetLog = packetLog[1:]
 	}
+	packetLogMu.Unlock()
+	log.Infof("Processing packet: timestamp=%v, length=%d",
+		packet.Timestamp, len(packet.Data))
 
-	if _, err := logFile.Write(append(jsonPacket, '\n')); err != nil {
-		return err
+	// Log packet to JSON file
+	if err := logPacketToFile(packet); err != nil {
+		log.Errorf("Failed to log packet to file: %v", err)
 	}
 
-	return nil
+	metrics.packetReceived()
+	start := time.Now()
+	// A sender told to resend a packet does not expect it to be shown.
+	nak := packet.FromPort && currentConfig().Response.Enabled
+	checksumOK, err := parsePacket(packet.Data, nak)
+	metrics.observeParse(time.Since(start))
+	notifyNewPacket(packet.Timestamp) // Notify clients about the new packet
+	if packet.FromPort {
+		respondToPacket(checksumOK, err)
+	}
 }
 
-func processPackets() {
-	log.Info("Started processing packets")
-	for packet := range packetChan {
-		packetLog = append(packetLog, packet)
-		if len(packetLog) > 100 {
-			packetLog = packetLog[1:]
-		}
-		log.Infof("Processing packet: timestamp=%v, length=%d",
-			packet.Timestamp, len(packet.Data))
+// parseData decodes a packet and shows it on the display, returning whether
+// its checksum matched and why it was rejected, if it was.
+func parseData(data []byte) (bool, error) {
+	return parsePacket(data, false)
+}
+
+// parsePacket is parseData, except that with skipBadChecksum set a packet
+// whose checksum does not match leaves the display alone.
+func parsePacket(data []byte, skipBadChecksum bool) (bool, error) {
+	log.Infof("Parsing data: length=%d, first byte=0x%02X, last byte=0x%02X", len(data), data[0], data[len(data)-1])
 
-		// Log packet to JSON file
-		if err := logPacketToFile(packet); err != nil {
-			log.Errorf("Failed to log packet to file: %v", err)
+	frame, checksumOK, err := activeDecoder().decode(data)
+	if !checksumOK {
+		metrics.checksumError()
+	}
+	if err != nil {
+		log.Warn(err)
+		if rejected, ok := err.(*packetError); ok {
+			metrics.packetRejected(rejected.reason)
 		}
+		return checksumOK, err
+	}
+	if frame == nil {
+		return checksumOK, nil
+	}
+	if !checksumOK && skipBadChecksum {
+		log.Warn("Not showing a packet with a bad checksum")
+		return checksumOK, nil
+	}
+
+	updatedPixels := applyFrame(frame)
+
+	log.Infof("Data parsed successfully. Updated %d pixels.", updatedPixels)
 
-		parseData(packet.Data)
-		notifyNewPacket() // Notify clients about the new packet
+	// Log the first few rows of the display for debugging
+	display.mu.Lock()
+	for i := 0; i < min(5, len(display.pixels)); i++ {
+		log.Infof("Row %d: %v", i, display.pixels[i][:min(10, len(display.pixels[i]))])
 	}
+	display.mu.Unlock()
+	return checksumOK, nil
 }
 
-func parseData(data []byte) {
-	log.Infof("Parsing data: length=%d, first byte=0x%02X, last byte=0x%02X", len(data), data[0], data[len(data)-1])
+// resetReassembly discards any partially received packet.
+func resetReassembly() {
+	partialPacket = nil
+}
+
+// reassemblePacket adds data read from the serial port to any partially
+// received packet and returns the packets it completes.
+func reassemblePacket(data []byte) [][]byte {
+	var completePackets [][]byte
+	completePackets, partialPacket = activeDecoder().split(append(partialPacket, data...))
+	return completePackets
+}
+
+// hanoverDecoder decodes Hanover packets: STX, a command, the address as an
+// ASCII digit, the resolution and pixel data as ASCII hex, ETX and an ASCII
+// hex checksum.
+type hanoverDecoder struct{}
+
+func (hanoverDecoder) name() string { return protocolHanover }
+
+func (hanoverDecoder) split(partialPacket []byte) ([][]byte, []byte) {
+    var completePackets [][]byte
+
+    for len(partialPacket) > 0 {
+        // Find start byte
+        startIndex := bytes.IndexByte(partialPacket, 0x02)
+        if startIndex == -1 {
+            // No start byte found, clear partial packet
+            return completePackets, nil
+        }
+
+        // Remove any data before the start byte
+        partialPacket = partialPacket[startIndex:]
+
+        // Find end byte
+        endIndex := bytes.IndexByte(partialPacket, 0x03)
+        if endIndex == -1 || len(partialPacket) < endIndex+3 {
+            // End byte not found or not enough data for checksum, keep accumulating
+            return completePackets, partialPacket
+        }
+
+        // We have a complete packet
+        completePacket := partialPacket[:endIndex+3]
+        completePackets = append(completePackets, completePacket)
+
+        // Remove the complete packet from partial data
+        partialPacket = partialPacket[endIndex+3:]
+    }
+
+    return completePackets, partialPacket
+}
+
+func (hanoverDecoder) decode(data []byte) ([][]bool, bool, error) {
     if len(data) < 9 {
-        log.Warn("Received data too short")
-        return
+        return nil, true, rejectPacket(rejectTooShort, "Received data too short")
     }
 
     if data[0] != 0x02 || data[len(data)-3] != 0x03 {
-        log.Warnf("Invalid start or end byte: start=0x%02X, end=0x%02X", data[0], data[len(data)-3])
-        return
+        return nil, true, rejectPacket(rejectFraming, "Invalid start or end byte: start=0x%02X, end=0x%02X", data[0], data[len(data)-3])
     }
 
+    checksumOK := fmt.Sprintf("%02X", hanoverChecksum(data[:len(data)-2])) == string(data[len(data)-2:])
+
     // Parse command (we're not using it currently, but it might be useful later)
     command := data[1]
     log.Infof("Command: %X", command)
@@ -93,22 +218,24 @@ func parseData(data []byte) {
     addressStr := string(data[2])
     address, err := strconv.Atoi(addressStr)
     if err != nil {
-        log.Warnf("Error parsing address: %v", err)
-        return
+        return nil, checksumOK, rejectPacket(rejectAddressFormat, "Error parsing address: %v", err)
     }
-    if address != config.Address {
-        log.Warnf("Message not for this display. Expected: %d, Got: %d", config.Address, address)
-        return
+    panel, ok := panelFor(protocolHanover, address)
+    if !ok {
+        cfg := currentConfig()
+        if len(cfg.Panels) > 0 {
+            return nil, checksumOK, rejectPacket(rejectWrongAddress, "Message not for any panel of this display. Got: %d", address)
+        }
+        return nil, checksumOK, rejectPacket(rejectWrongAddress, "Message not for this display. Expected: %d, Got: %d", cfg.Address, address)
     }
 
     // Parse resolution
     resolutionStr := string(data[3:5])
     resolution, err := strconv.ParseUint(resolutionStr, 16, 16)
     if err != nil {
-        log.Warnf("Error parsing resolution: %v", err)
-        return
+        return nil, checksumOK, rejectPacket(rejectResolution, "Error parsing resolution: %v", err)
     }
-    expectedResolution := uint64((config.Rows * config.Columns) / 8)
+    expectedResolution := uint64((panel.Rows * panel.Columns) / 8)
     if resolution != expectedResolution {
         log.Warnf("Unexpected resolution. Expected: %d, Got: %d", expectedResolution, resolution)
     }
@@ -117,50 +244,27 @@ func parseData(data []byte) {
     pixelData := data[5 : len(data)-3]
     log.Infof("Pixel data length: %d", len(pixelData))
 
-    updatedPixels := updateDisplay(pixelData)
-
-    log.Infof("Data parsed successfully. Updated %d pixels.", updatedPixels)
-
-    // Log the first few rows of the display for debugging
-    for i := 0; i < min(5, len(display.pixels)); i++ {
-        log.Infof("Row %d: %v", i, display.pixels[i][:min(10, len(display.pixels[i]))])
-    }
+    frame := snapshotDisplay()
+    local := panel.extract(frame)
+    overlayPixelData(local, pixelData)
+    panel.place(frame, local)
+    return frame, checksumOK, nil
 }
 
-func reassemblePacket(data []byte) [][]byte {
-    var completePackets [][]byte
-
-    // Append new data to any existing partial packet
-    partialPacket = append(partialPacket, data...)
-
-    for len(partialPacket) > 0 {
-        // Find start byte
-        startIndex := bytes.IndexByte(partialPacket, 0x02)
-        if startIndex == -1 {
-            // No start byte found, clear partial packet
-            partialPacket = nil
-            return completePackets
-        }
-
-        // Remove any data before the start byte
-        partialPacket = partialPacket[startIndex:]
-
-        // Find end byte
-        endIndex := bytes.IndexByte(partialPacket, 0x03)
-        if endIndex == -1 || len(partialPacket) < endIndex+3 {
-            // End byte not found or not enough data for checksum, keep accumulating
-            return completePackets
-        }
-
-        // We have a complete packet
-        completePacket := partialPacket[:endIndex+3]
-        completePackets = append(completePackets, completePacket)
-
-        // Remove the complete packet from partial data
-        partialPacket = partialPacket[endIndex+3:]
-    }
+func (hanoverDecoder) fields(data []byte) (*decodedPacket, error) {
+	return decodeHanoverPacket(data)
+}
 
-    return completePackets
+func (hanoverDecoder) describe(data []byte) string {
+	decoded, err := decodeHanoverPacket(data)
+	if err != nil {
+		return err.Error()
+	}
+	checksum := "checksum ok"
+	if !decoded.ChecksumOK() {
+		checksum = fmt.Sprintf("checksum %02X, expected %02X", decoded.Checksum, decoded.ExpectedChecksum)
+	}
+	return fmt.Sprintf("address %d  resolution %d  %s", decoded.Address, decoded.Resolution, checksum)
 }
 
 func min(a, b int) int {
@@ -169,3 +273,65 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// decodedPacket is a packet split into its fields. Fields the packet's
+// protocol does not have are left zero.
+type decodedPacket struct {
+	Protocol   string
+	Command    byte
+	Address    int
+	Resolution int
+	PixelData  []byte
+	// Text is the text of an IBIS telegram.
+	Text string
+	// HasChecksum is set for protocols that check packets with a checksum
+	// or parity byte.
+	HasChecksum      bool
+	Checksum         byte
+	ExpectedChecksum byte
+}
+
+// ChecksumOK reports whether the packet's checksum matches its contents, or
+// its protocol has none.
+func (p *decodedPacket) ChecksumOK() bool {
+	return !p.HasChecksum || p.Checksum == p.ExpectedChecksum
+}
+
+// decodeHanoverPacket splits a complete packet (STX through checksum) into
+// its fields without applying it to the display.
+func decodeHanoverPacket(data []byte) (*decodedPacket, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	if data[0] != hanoverSTX {
+		return nil, fmt.Errorf("invalid start byte 0x%02X", data[0])
+	}
+	if data[len(data)-3] != hanoverETX {
+		return nil, fmt.Errorf("invalid end byte 0x%02X", data[len(data)-3])
+	}
+
+	address, err := strconv.Atoi(string(data[2]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", data[2])
+	}
+	resolution, err := strconv.ParseUint(string(data[3:5]), 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolution %q", data[3:5])
+	}
+	checksum, err := strconv.ParseUint(string(data[len(data)-2:]), 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum %q", data[len(data)-2:])
+	}
+
+	return &decodedPacket{
+		Protocol:         protocolHanover,
+		Command:          data[1],
+		Address:          address,
+		Resolution:       int(resolution),
+		PixelData:        data[5 : len(data)-3],
+		HasChecksum:      true,
+		Checksum:         byte(checksum),
+		ExpectedChecksum: hanoverChecksum(data[:len(data)-2]),
+	}, nil
+}
+
@@ -48,50 +48,48 @@ func TestReassemblePacket(t *testing.T) {
 }
 
 func TestParseData(t *testing.T) {
-    config = Config{
-        Columns: 96,
-        Rows:    16,
-        Address: 1,
-    }
-    initializeDisplay()
+	withProtocol(t, Config{Columns: 8, Rows: 8, Address: 1})
 
-    testCases := []struct {
-        name           string
-        input          []byte
-        expectedPixels int
-    }{
-        {
-            name:           "Valid packet",
-            input:          []byte{0x02, '1', '1', '0', 'C', 'A', 'A', 'A', 'A', 0x03, 0x00, 0x00},
-            expectedPixels: 2,
-        },
-        {
-            name:           "Invalid start byte",
-            input:          []byte{0x03, '1', '1', '0', 'C', 'A', 'A', 'A', 'A', 0x03, 0x00, 0x00},
-            expectedPixels: 0,
-        },
-        {
-            name:           "Invalid end byte",
-            input:          []byte{0x02, '1', '1', '0', 'C', 'A', 'A', 'A', 'A', 0x02, 0x00, 0x00},
-            expectedPixels: 0,
-        },
-        {
-            name:           "Wrong address",
-            input:          []byte{0x02, '1', '2', '0', 'C', 'A', 'A', 'A', 'A', 0x03, 0x00, 0x00},
-            expectedPixels: 0,
-        },
-    }
+	arrow := asciiFrame(t, `
+...#....
+...##...
+#######.
+########
+#######.
+...##...
+...#....
+........
+`)
+	blank := frameToASCII(newFrame(8, 8))
+	valid := hanoverPacket(t, 1, arrow)
+	corrupt := func(i int, b byte) []byte {
+		packet := append([]byte(nil), valid...)
+		packet[i] = b
+		return packet
+	}
 
-    for _, tc := range testCases {
-        t.Run(tc.name, func(t *testing.T) {
-            initializeDisplay() // Reset display before each test
-            parseData(tc.input)
-            updatedPixels := countUpdatedPixels()
-            if updatedPixels != tc.expectedPixels {
-                t.Errorf("Expected %d updated pixels, got %d", tc.expectedPixels, updatedPixels)
-            }
-        })
-    }
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{"Valid packet", valid, frameToASCII(arrow)},
+		// A wrong checksum is logged and counted, but still displayed.
+		{"Bad checksum", corrupt(len(valid)-1, '!'), frameToASCII(arrow)},
+		{"Invalid start byte", corrupt(0, 0x03), blank},
+		{"Invalid end byte", corrupt(len(valid)-3, 0x02), blank},
+		{"Wrong address", hanoverPacket(t, 2, arrow), blank},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			initializeDisplay() // Reset display before each test
+			parseData(tc.input)
+			if got := frameToASCII(snapshotDisplay()); got != tc.expected {
+				t.Errorf("Expected the display to show\n%s\ngot\n%s", tc.expected, got)
+			}
+		})
+	}
 }
 
 func TestLogPacketToFile(t *testing.T) {
@@ -157,3 +155,36 @@ func countUpdatedPixels() int {
 	}
 	return count
 }
+
+func TestDecodeHanoverPacket(t *testing.T) {
+	frame := newFrame(16, 8)
+	frame[0][0] = true
+	frame[15][7] = true
+	packet := hanoverPacket(t, 3, frame)
+
+	decoded, err := decodeHanoverPacket(packet)
+	if err != nil {
+		t.Fatalf("decodeHanoverPacket failed: %v", err)
+	}
+	if decoded.Command != '1' || decoded.Address != 3 || decoded.Resolution != 16 {
+		t.Errorf("Unexpected fields: %+v", decoded)
+	}
+	if !decoded.ChecksumOK() {
+		t.Errorf("Expected checksum %02X to match %02X", decoded.Checksum, decoded.ExpectedChecksum)
+	}
+
+	pixels := newFrame(16, 8)
+	overlayPixelData(pixels, decoded.PixelData)
+	if !pixels[0][0] || !pixels[15][7] || pixels[0][1] {
+		t.Errorf("Decoded pixels do not match the encoded frame")
+	}
+
+	packet[len(packet)-1] = '0'
+	packet[len(packet)-2] = '0'
+	if decoded, err := decodeHanoverPacket(packet); err != nil || decoded.ChecksumOK() {
+		t.Errorf("Expected a checksum mismatch, got err=%v", err)
+	}
+	if _, err := decodeHanoverPacket([]byte{0x02, '1', '1', 0x03}); err == nil {
+		t.Error("Expected an error for a short packet")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Packet log formats.
+const (
+	logFormatJSON   = "json"   // one Packet per line, as read by replay
+	logFormatNDJSON = "ndjson" // one decoded packet per line
+	logFormatHex    = "hex"    // timestamp and hex dump per line
+	logFormatRaw    = "raw"    // binary records: timestamp, length, data
+)
+
+// PacketLogConfig configures the log of every packet the simulator
+// receives. Rotation happens when the file would grow past MaxSizeMB or has
+// been open for MaxAge, whichever comes first; 0 disables either trigger.
+// Rotated files are kept until there are more than MaxBackups of them or
+// they were rotated more than MaxBackupAge ago, again with 0 meaning no
+// limit.
+type PacketLogConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Path         string        `yaml:"path"`
+	Format       string        `yaml:"format"`
+	MaxSizeMB    int           `yaml:"max_size_mb"`
+	MaxAge       time.Duration `yaml:"max_age"`
+	Compress     bool          `yaml:"compress"`
+	MaxBackups   int           `yaml:"max_backups"`
+	MaxBackupAge time.Duration `yaml:"max_backup_age"`
+}
+
+func defaultPacketLog() PacketLogConfig {
+	return PacketLogConfig{
+		Enabled: true,
+		Path:    "packet_log.json",
+		Format:  logFormatJSON,
+	}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c PacketLogConfig) validate(problems []string) []string {
+	if !c.Enabled {
+		return problems
+	}
+	if c.Path == "" {
+		problems = append(problems, "packet_log.path must be set when logging is enabled")
+	}
+	switch c.Format {
+	case logFormatJSON, logFormatNDJSON, logFormatHex, logFormatRaw:
+	default:
+		problems = append(problems, fmt.Sprintf("packet_log.format must be json, ndjson, hex or raw, got %q", c.Format))
+	}
+	if c.MaxSizeMB < 0 || c.MaxAge < 0 || c.MaxBackups < 0 || c.MaxBackupAge < 0 {
+		problems = append(problems, "packet_log rotation and retention limits must not be negative")
+	}
+	return problems
+}
+
+var (
+	logFile   *os.File
+	logMutex  sync.Mutex
+	logConfig PacketLogConfig
+	// logStarted is set between initPacketLogging and closePacketLogging,
+	// even if logging is disabled.
+	logStarted bool
+	// logSize and logOpened track the current file for rotation.
+	logSize   int64
+	logOpened time.Time
+	// logRotations tracks compression and pruning of rotated files.
+	logRotations sync.WaitGroup
+)
+
+// initPacketLogging opens the packet log described by cfg, unless logging
+// is disabled.
+func initPacketLogging(cfg PacketLogConfig) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	logStarted = true
+	if !cfg.Enabled {
+		log.Info("Packet logging disabled")
+		return nil
+	}
+	logConfig = cfg
+	if err := openPacketLog(); err != nil {
+		return err
+	}
+	log.Infof("Logging packets to %s (%s)", cfg.Path, cfg.Format)
+	return nil
+}
+
+// packetLogStarted reports whether initPacketLogging has been called.
+func packetLogStarted() bool {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	return logStarted
+}
+
+func openPacketLog() error {
+	f, err := os.OpenFile(logConfig.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	logFile, logSize, logOpened = f, info.Size(), simClock.Now()
+	return nil
+}
+
+// closePacketLogging flushes and closes the packet log, waiting for any
+// rotated file still being compressed.
+func closePacketLogging() {
+	logMutex.Lock()
+	if logFile != nil {
+		if err := logFile.Sync(); err != nil {
+			log.Warnf("Error flushing packet log: %v", err)
+		}
+		logFile.Close()
+		logFile = nil
+	}
+	logConfig = PacketLogConfig{}
+	logStarted = false
+	logMutex.Unlock()
+
+	logRotations.Wait()
+}
+
+func logPacketToFile(packet Packet) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if logFile == nil {
+		return nil
+	}
+
+	entry, err := formatLogEntry(logConfig.Format, packet)
+	if err != nil {
+		return err
+	}
+
+	if logConfig.Path != "" && needsRotation(int64(len(entry))) {
+		if err := rotatePacketLog(); err != nil {
+			return err
+		}
+	}
+
+	n, err := logFile.Write(entry)
+	logSize += int64(n)
+	return err
+}
+
+// decodedLogEntry is the ndjson log format: the packet's raw bytes as hex
+// alongside its decoded header fields.
+type decodedLogEntry struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Length           int       `json:"length"`
+	Data             string    `json:"data"`
+	Protocol         string    `json:"protocol,omitempty"`
+	Command          string    `json:"command,omitempty"`
+	Address          int       `json:"address,omitempty"`
+	Resolution       int       `json:"resolution,omitempty"`
+	Text             string    `json:"text,omitempty"`
+	Checksum         string    `json:"checksum,omitempty"`
+	ExpectedChecksum string    `json:"expected_checksum,omitempty"`
+	ChecksumOK       bool      `json:"checksum_ok"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// formatLogEntry encodes packet as one entry of the given log format.
+func formatLogEntry(format string, packet Packet) ([]byte, error) {
+	switch format {
+	case "", logFormatJSON:
+		data, err := json.Marshal(packet)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+
+	case logFormatNDJSON:
+		entry := decodedLogEntry{
+			Timestamp: packet.Timestamp,
+			Length:    len(packet.Data),
+			Data:      hex.EncodeToString(packet.Data),
+		}
+		decoded, err := activeDecoder().fields(packet.Data)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Protocol = decoded.Protocol
+			entry.Command = string(decoded.Command)
+			if decoded.Command >= 0x80 {
+				entry.Command = fmt.Sprintf("0x%02X", decoded.Command)
+			}
+			entry.Address = decoded.Address
+			entry.Resolution = decoded.Resolution
+			entry.Text = decoded.Text
+			if decoded.HasChecksum {
+				entry.Checksum = fmt.Sprintf("%02X", decoded.Checksum)
+				entry.ExpectedChecksum = fmt.Sprintf("%02X", decoded.ExpectedChecksum)
+			}
+			entry.ChecksumOK = decoded.ChecksumOK()
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+
+	case logFormatHex:
+		return []byte(fmt.Sprintf("%s % X\n", packet.Timestamp.Format(time.RFC3339Nano), packet.Data)), nil
+
+	case logFormatRaw:
+		// Each record is the timestamp in Unix nanoseconds and the data
+		// length, both big-endian, followed by the data itself.
+		record := make([]byte, 12, 12+len(packet.Data))
+		binary.BigEndian.PutUint64(record[0:8], uint64(packet.Timestamp.UnixNano()))
+		binary.BigEndian.PutUint32(record[8:12], uint32(len(packet.Data)))
+		return append(record, packet.Data...), nil
+	}
+	return nil, fmt.Errorf("unknown packet log format %q", format)
+}
+
+// needsRotation reports whether the current file must be rotated before an
+// entry of size n is written to it.
+func needsRotation(n int64) bool {
+	if logSize == 0 {
+		return false
+	}
+	if logConfig.MaxSizeMB > 0 && logSize+n > int64(logConfig.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return logConfig.MaxAge > 0 && simClock.Now().Sub(logOpened) >= logConfig.MaxAge
+}
+
+// rotatePacketLog moves the current file aside under a timestamped name and
+// starts a new one. Compression and pruning of old files happen in the
+// background so packets keep flowing.
+func rotatePacketLog() error {
+	if err := logFile.Close(); err != nil {
+		log.Warnf("Error closing packet log: %v", err)
+	}
+	logFile = nil
+
+	rotated := rotatedLogName(logConfig.Path, simClock.Now())
+	if err := os.Rename(logConfig.Path, rotated); err != nil {
+		// Keep logging to the current file rather than not at all.
+		if reopenErr := openPacketLog(); reopenErr != nil {
+			return reopenErr
+		}
+		return fmt.Errorf("error rotating packet log: %v", err)
+	}
+	if err := openPacketLog(); err != nil {
+		return err
+	}
+	log.Infof("Rotated packet log to %s", rotated)
+
+	cfg := logConfig
+	logRotations.Add(1)
+	go func() {
+		defer logRotations.Done()
+		if cfg.Compress {
+			if err := compressFile(rotated); err != nil {
+				log.Errorf("Error compressing %s: %v", rotated, err)
+			}
+		}
+		pruneRotatedLogs(cfg)
+	}()
+	return nil
+}
+
+// rotatedLogLayout is the layout of the time in rotated log names.
+const rotatedLogLayout = "20060102T150405.000000"
+
+// rotatedLogName returns the name a log file is rotated to at t, e.g.
+// packet_log-20240102T150405.000000.json.
+func rotatedLogName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + t.Format(rotatedLogLayout) + ext
+}
+
+// rotatedLogTime returns the time a file returned by rotatedLogs was rotated
+// at, read from its name.
+func rotatedLogTime(path, name string) (time.Time, bool) {
+	ext := filepath.Ext(path)
+	stamp := strings.TrimPrefix(strings.TrimSuffix(name, ".gz"), strings.TrimSuffix(path, ext)+"-")
+	t, err := time.ParseInLocation(rotatedLogLayout, strings.TrimSuffix(stamp, ext), simClock.Now().Location())
+	return t, err == nil
+}
+
+// compressFile replaces name with a gzipped name.gz.
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// rotatedLogs returns the rotated files belonging to path, oldest first.
+func rotatedLogs(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	pattern := strings.TrimSuffix(path, ext) + "-*" + ext
+	plain, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(pattern + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	files := append(plain, compressed...)
+	// The timestamp in the name sorts chronologically.
+	sort.Strings(files)
+	return files, nil
+}
+
+// pruneRotatedLogs deletes rotated files beyond cfg's retention limits.
+func pruneRotatedLogs(cfg PacketLogConfig) {
+	files, err := rotatedLogs(cfg.Path)
+	if err != nil {
+		log.Errorf("Error listing rotated packet logs: %v", err)
+		return
+	}
+
+	var remove []string
+	if cfg.MaxBackups > 0 && len(files) > cfg.MaxBackups {
+		remove = append(remove, files[:len(files)-cfg.MaxBackups]...)
+		files = files[len(files)-cfg.MaxBackups:]
+	}
+	if cfg.MaxBackupAge > 0 {
+		// The age comes from the name rather than the file's modification
+		// time, so it follows the simulator clock.
+		for _, name := range files {
+			rotated, ok := rotatedLogTime(cfg.Path, name)
+			if ok && simClock.Now().Sub(rotated) > cfg.MaxBackupAge {
+				remove = append(remove, name)
+			}
+		}
+	}
+
+	for _, name := range remove {
+		if err := os.Remove(name); err != nil {
+			log.Warnf("Error removing old packet log %s: %v", name, err)
+			continue
+		}
+		log.Infof("Removed old packet log %s", name)
+	}
+}
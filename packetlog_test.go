@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatLogEntry(t *testing.T) {
+	packet := Packet{
+		Timestamp: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Data:      hanoverPacket(t, 1, newFrame(16, 8)),
+	}
+
+	hexEntry, err := formatLogEntry(logFormatHex, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hexEntry), "2024-01-02T15:04:05Z 02 31 31 31 30 ") {
+		t.Errorf("Unexpected hex entry %q", hexEntry)
+	}
+
+	raw, err := formatLogEntry(logFormatRaw, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := int64(binary.BigEndian.Uint64(raw[0:8])); ts != packet.Timestamp.UnixNano() {
+		t.Errorf("Expected raw timestamp %d, got %d", packet.Timestamp.UnixNano(), ts)
+	}
+	if n := binary.BigEndian.Uint32(raw[8:12]); int(n) != len(packet.Data) || !bytes.Equal(raw[12:], packet.Data) {
+		t.Errorf("Expected raw record to hold the %d packet bytes, got length %d", len(packet.Data), n)
+	}
+
+	decoded, err := formatLogEntry(logFormatNDJSON, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry decodedLogEntry
+	if err := json.Unmarshal(decoded, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Protocol != protocolHanover || entry.Address != 1 || entry.Resolution != 0x10 || !entry.ChecksumOK || entry.Error != "" {
+		t.Errorf("Unexpected decoded entry %+v", entry)
+	}
+}
+
+func TestFormatLogEntryProtocol(t *testing.T) {
+	withProtocol(t, Config{Columns: 28, Rows: 7, Address: 0x2A, Protocol: protocolAlfaZeta})
+
+	packet := Packet{Data: alfaZetaPacket(0x83, 0x2A, make([]byte, 28))}
+	decoded, err := formatLogEntry(logFormatNDJSON, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry decodedLogEntry
+	if err := json.Unmarshal(decoded, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Protocol != protocolAlfaZeta || entry.Command != "0x83" || entry.Address != 0x2A || entry.Checksum != "" || !entry.ChecksumOK || entry.Error != "" {
+		t.Errorf("Expected the packet to be decoded as Alfa-Zeta, got %+v", entry)
+	}
+}
+
+func TestPacketLogRotation(t *testing.T) {
+	clock := useVirtualClock(t)
+	path := filepath.Join(t.TempDir(), "packets.log")
+	err := initPacketLogging(PacketLogConfig{
+		Enabled:    true,
+		Path:       path,
+		Format:     logFormatHex,
+		MaxAge:     time.Second,
+		Compress:   true,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		packet := Packet{Timestamp: clock.Now(), Data: []byte{0x02, byte('0' + i), 0x03}}
+		if err := logPacketToFile(packet); err != nil {
+			t.Fatalf("logPacketToFile failed: %v", err)
+		}
+		clock.Advance(time.Second)
+	}
+	closePacketLogging()
+
+	rotated, err := rotatedLogs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated logs to be kept, got %q", rotated)
+	}
+	for _, name := range rotated {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Expected %s to be compressed", name)
+		}
+	}
+
+	// The newest rotated file holds the third packet, the live file the
+	// fourth.
+	f, err := os.Open(rotated[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(contents), " 02 32 03\n") {
+		t.Errorf("Unexpected rotated contents %q", contents)
+	}
+	if current, _ := os.ReadFile(path); !strings.HasSuffix(string(current), " 02 33 03\n") {
+		t.Errorf("Unexpected current contents %q", current)
+	}
+}
+
+func TestPacketLogDisabled(t *testing.T) {
+	dir := t.TempDir()
+	if err := initPacketLogging(PacketLogConfig{Path: filepath.Join(dir, "packets.log")}); err != nil {
+		t.Fatal(err)
+	}
+	defer closePacketLogging()
+
+	if err := logPacketToFile(Packet{Data: []byte{0x02, 0x03}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected nothing to be written with logging disabled, got %d files", len(entries))
+	}
+}
+
+func TestPruneRotatedLogsByAge(t *testing.T) {
+	clock := useVirtualClock(t)
+	path := filepath.Join(t.TempDir(), "packets.log")
+
+	// Backups rotated a day and an hour before the simulator clock, which
+	// is years behind the wall clock. Their files are all new.
+	old := rotatedLogName(path, clock.Now().Add(-24*time.Hour)) + ".gz"
+	recent := rotatedLogName(path, clock.Now().Add(-time.Hour))
+	for _, name := range []string{old, recent} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneRotatedLogs(PacketLogConfig{Path: path, MaxBackupAge: 12 * time.Hour})
+
+	if rotated, _ := rotatedLogs(path); len(rotated) != 1 || rotated[0] != recent {
+		t.Errorf("Expected only %s to be kept, got %q", recent, rotated)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PanelConfig places one addressed module on a sign built from several.
+// Columns and Rows are the module's own size, X and Y its top-left corner on
+// the sign, and Rotation how far it is turned clockwise (0, 90, 180 or 270
+// degrees) when mounted. Protocol is the protocol the module speaks, the
+// display's protocol if empty.
+type PanelConfig struct {
+	Address  int    `yaml:"address"`
+	X        int    `yaml:"x"`
+	Y        int    `yaml:"y"`
+	Columns  int    `yaml:"columns"`
+	Rows     int    `yaml:"rows"`
+	Rotation int    `yaml:"rotation"`
+	Protocol string `yaml:"protocol"`
+}
+
+// footprint returns the width and height the panel covers on the sign.
+func (p PanelConfig) footprint() (int, int) {
+	if p.Rotation == 90 || p.Rotation == 270 {
+		return p.Rows, p.Columns
+	}
+	return p.Columns, p.Rows
+}
+
+// toSign maps a dot of the module to its position on the sign.
+func (p PanelConfig) toSign(row, col int) (int, int) {
+	switch p.Rotation {
+	case 90:
+		return p.Y + col, p.X + p.Rows - 1 - row
+	case 180:
+		return p.Y + p.Rows - 1 - row, p.X + p.Columns - 1 - col
+	case 270:
+		return p.Y + p.Columns - 1 - col, p.X + row
+	default:
+		return p.Y + row, p.X + col
+	}
+}
+
+// extract returns the module's part of a sign frame, in the module's own
+// orientation. Dots outside the frame are off.
+func (p PanelConfig) extract(frame [][]bool) [][]bool {
+	local := newFrame(p.Rows, p.Columns)
+	for row := 0; row < p.Rows; row++ {
+		for col := 0; col < p.Columns; col++ {
+			r, c := p.toSign(row, col)
+			if r < len(frame) && c < len(frame[r]) {
+				local[row][col] = frame[r][c]
+			}
+		}
+	}
+	return local
+}
+
+// place draws a frame in the module's own orientation onto its part of a
+// sign frame.
+func (p PanelConfig) place(frame, local [][]bool) {
+	for row := 0; row < p.Rows && row < len(local); row++ {
+		for col := 0; col < p.Columns && col < len(local[row]); col++ {
+			r, c := p.toSign(row, col)
+			if r < len(frame) && c < len(frame[r]) {
+				frame[r][c] = local[row][col]
+			}
+		}
+	}
+}
+
+// signPanels returns the panels making up the sign: the configured ones, or
+// a single panel covering the whole display at the display's address. Each
+// panel's Protocol is set to the protocol it decodes.
+func signPanels() []PanelConfig {
+	cfg := currentConfig()
+	if len(cfg.Panels) == 0 {
+		return []PanelConfig{{Address: cfg.Address, Columns: cfg.Columns, Rows: cfg.Rows, Protocol: panelProtocol("", cfg.Protocol)}}
+	}
+	panels := make([]PanelConfig, len(cfg.Panels))
+	for i, p := range cfg.Panels {
+		p.Protocol = panelProtocol(p.Protocol, cfg.Protocol)
+		panels[i] = p
+	}
+	return panels
+}
+
+// panelProtocol returns the protocol a panel decodes: its own, or the
+// display's if it has none, with protocol: auto following the detected
+// protocol.
+func panelProtocol(protocol, displayProtocol string) string {
+	if protocol == "" {
+		protocol = displayProtocol
+	}
+	name, err := lookupProtocol(protocol)
+	if err != nil {
+		return protocolHanover
+	}
+	if name == protocolAuto {
+		return detectedProtocol()
+	}
+	return name
+}
+
+// protocolPanels returns the panels speaking protocol.
+func protocolPanels(protocol string) []PanelConfig {
+	var panels []PanelConfig
+	for _, p := range signPanels() {
+		if p.Protocol == protocol {
+			panels = append(panels, p)
+		}
+	}
+	return panels
+}
+
+// panelFor returns the panel speaking protocol at the given address.
+func panelFor(protocol string, address int) (PanelConfig, bool) {
+	for _, p := range protocolPanels(protocol) {
+		if p.Address == address {
+			return p, true
+		}
+	}
+	return PanelConfig{}, false
+}
+
+// signProtocols returns the protocols the sign's panels speak, sorted.
+func signProtocols() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, p := range signPanels() {
+		if !seen[p.Protocol] {
+			seen[p.Protocol] = true
+			names = append(names, p.Protocol)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validatePanels appends a problem to problems for each panel that does not
+// fit on a columns x rows sign, shares an address with another panel of its
+// protocol or overlaps another. displayProtocol is the protocol of panels
+// that do not set their own.
+func validatePanels(panels []PanelConfig, columns, rows int, displayProtocol string, problems []string) []string {
+	owner := make([][]int, rows)
+	for row := range owner {
+		owner[row] = make([]int, columns)
+	}
+	type protocolAddress struct {
+		protocol string
+		address  int
+	}
+	addresses := make(map[protocolAddress]bool)
+	for i, p := range panels {
+		name := fmt.Sprintf("panels[%d]", i)
+		protocol := displayProtocol
+		if p.Protocol != "" {
+			var err error
+			if protocol, err = lookupProtocol(p.Protocol); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.protocol: %v", name, err))
+			} else if protocol == protocolAuto {
+				problems = append(problems, fmt.Sprintf("%s.protocol cannot be auto; leave it out to follow the display's protocol", name))
+			}
+		}
+		switch protocol {
+		case protocolAlfaZeta:
+			if !validAlfaZetaAddress(p.Address) {
+				problems = append(problems, fmt.Sprintf("%s.address must be between 0 and 254 for the alfazeta protocol, got %d", name, p.Address))
+			}
+		case protocolIBIS:
+			// IBIS telegrams are broadcast, so the address is not used.
+		default:
+			if !validHanoverAddress(p.Address) {
+				problems = append(problems, fmt.Sprintf("%s.address must be between 1 and 9, got %d", name, p.Address))
+			}
+		}
+		if key := (protocolAddress{protocol, p.Address}); addresses[key] {
+			problems = append(problems, fmt.Sprintf("%s.address %d is used by another panel", name, p.Address))
+		} else {
+			addresses[key] = true
+		}
+		if p.Rotation != 0 && p.Rotation != 90 && p.Rotation != 180 && p.Rotation != 270 {
+			problems = append(problems, fmt.Sprintf("%s.rotation must be 0, 90, 180 or 270, got %d", name, p.Rotation))
+			continue
+		}
+		if p.Columns <= 0 || p.Rows <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must have positive columns and rows", name))
+			continue
+		}
+		width, height := p.footprint()
+		if p.X < 0 || p.Y < 0 || p.X+width > columns || p.Y+height > rows {
+			problems = append(problems, fmt.Sprintf("%s (%dx%d at %d,%d) does not fit on the %dx%d sign", name, width, height, p.X, p.Y, columns, rows))
+			continue
+		}
+		overlaps := -1
+		for row := p.Y; row < p.Y+height; row++ {
+			for col := p.X; col < p.X+width; col++ {
+				if owner[row][col] != 0 && overlaps == -1 {
+					overlaps = owner[row][col] - 1
+				}
+				owner[row][col] = i + 1
+			}
+		}
+		if overlaps != -1 {
+			problems = append(problems, fmt.Sprintf("%s overlaps panels[%d]", name, overlaps))
+		}
+	}
+	return problems
+}
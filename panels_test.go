@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPanelRotation(t *testing.T) {
+	for _, tc := range []struct {
+		rotation int
+		row, col int
+	}{
+		// Where the module's top-left dot ends up on the sign.
+		{0, 1, 1},
+		{90, 1, 2},
+		{180, 2, 3},
+		{270, 3, 1},
+	} {
+		p := PanelConfig{X: 1, Y: 1, Columns: 3, Rows: 2, Rotation: tc.rotation}
+		local := newFrame(2, 3)
+		local[0][0] = true
+		frame := newFrame(4, 4)
+		p.place(frame, local)
+		if !frame[tc.row][tc.col] {
+			t.Errorf("rotation %d: expected the top-left dot at %d,%d, got\n%s", tc.rotation, tc.row, tc.col,
+				strings.Join(renderFrame(frame, tuiModeDots), "\n"))
+		}
+		if extracted := p.extract(frame); !extracted[0][0] {
+			t.Errorf("rotation %d: extract did not undo place", tc.rotation)
+		}
+	}
+}
+
+func TestPanelComposition(t *testing.T) {
+	withProtocol(t, Config{Columns: 16, Rows: 8, Address: 1, Panels: []PanelConfig{
+		{Address: 1, X: 0, Y: 0, Columns: 8, Rows: 8},
+		{Address: 2, X: 8, Y: 0, Columns: 8, Rows: 8, Rotation: 180},
+	}})
+
+	frame := newFrame(8, 16)
+	frame[0][0] = true  // top left of panel 1
+	frame[0][15] = true // top right of the sign: bottom right of panel 2 as mounted
+	frame[7][9] = true
+
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		t.Fatalf("encodeSignPackets failed: %v", err)
+	}
+	if len(packets) != 2 || packets[0][2] != '1' || packets[1][2] != '2' {
+		t.Fatalf("Expected a packet for each panel, got %q", packets)
+	}
+	for _, packet := range packets {
+		parseData(packet)
+	}
+	if got := snapshotDisplay(); !reflect.DeepEqual(got, frame) {
+		t.Errorf("Expected the panels to compose into the original frame, got\n%s", strings.Join(renderFrame(got, tuiModeDots), "\n"))
+	}
+
+	// A packet for one panel leaves the other alone.
+	parseData(hanoverPacket(t, 2, newFrame(8, 8)))
+	if !display.pixels[0][0] || display.pixels[0][15] || display.pixels[7][9] {
+		t.Error("Expected clearing panel 2 to leave panel 1 alone")
+	}
+	parseData(hanoverPacket(t, 3, newFrame(8, 8)))
+	if !display.pixels[0][0] {
+		t.Error("Expected a packet for an unknown panel to be ignored")
+	}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/display.png?scale=2", nil))
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected a PNG, got %d: %v", rec.Code, err)
+	}
+	if size := img.Bounds().Size(); size.X != 32 || size.Y != 16 {
+		t.Errorf("Expected a 32x16 image, got %v", size)
+	}
+	if r, g, _, _ := img.At(1, 1).RGBA(); r == 0 || g == 0 {
+		t.Error("Expected the lit top-left dot to be drawn")
+	}
+}
+
+func TestValidatePanels(t *testing.T) {
+	problems := validatePanels([]PanelConfig{
+		{Address: 1, Columns: 8, Rows: 8},
+		{Address: 1, X: 4, Columns: 8, Rows: 8},
+		{Address: 2, X: 10, Columns: 8, Rows: 8, Rotation: 90},
+		{Address: 3, Columns: 8, Rows: 8, Rotation: 45},
+	}, 16, 8, protocolHanover, nil)
+	for _, want := range []string{"panels[1].address 1 is used", "panels[1] overlaps panels[0]", "panels[2] (8x8 at 10,0) does not fit", "panels[3].rotation"} {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.Contains(problem, want)
+		}
+		if !found {
+			t.Errorf("Expected a problem containing %q, got %q", want, problems)
+		}
+	}
+}
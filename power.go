@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PowerConfig models the sign's coil drivers to estimate what each frame
+// costs the supply. Every flipped dot pulses one coil for PulseDuration,
+// drawing CoilCurrent and using CoilEnergy millijoules; the drivers pulse up
+// to DriverParallelism coils at once. A frame estimated to need more than
+// MaxFrameEnergy millijoules or MaxPeakCurrent amps is logged as over budget;
+// 0 disables either budget. GET /power returns it as JSON for the web UI.
+type PowerConfig struct {
+	CoilEnergy        float64       `yaml:"coil_energy_mj" json:"coil_energy_mj"`
+	CoilCurrent       float64       `yaml:"coil_current_a" json:"coil_current_a"`
+	PulseDuration     time.Duration `yaml:"pulse_duration" json:"pulse_duration_ns"`
+	DriverParallelism int           `yaml:"driver_parallelism" json:"driver_parallelism"`
+	MaxFrameEnergy    float64       `yaml:"max_frame_energy_mj" json:"max_frame_energy_mj"`
+	MaxPeakCurrent    float64       `yaml:"max_peak_current_a" json:"max_peak_current_a"`
+}
+
+// defaultPower describes a typical 24V flipdot driver; measure your own sign
+// for useful numbers.
+func defaultPower() PowerConfig {
+	return PowerConfig{
+		CoilEnergy:        10,
+		CoilCurrent:       0.4,
+		PulseDuration:     time.Millisecond,
+		DriverParallelism: 16,
+	}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c PowerConfig) validate(problems []string) []string {
+	if c.CoilEnergy < 0 || c.CoilCurrent < 0 || c.PulseDuration < 0 {
+		problems = append(problems, "power coil settings must not be negative")
+	}
+	if c.DriverParallelism < 1 {
+		problems = append(problems, fmt.Sprintf("power.driver_parallelism must be at least 1, got %d", c.DriverParallelism))
+	}
+	if c.MaxFrameEnergy < 0 || c.MaxPeakCurrent < 0 {
+		problems = append(problems, "power budgets must not be negative")
+	}
+	return problems
+}
+
+// powerEstimate is the estimated cost of showing one frame.
+type powerEstimate struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Flips       int       `json:"flips"`
+	Energy      float64   `json:"energy_mj"`
+	PeakCurrent float64   `json:"peak_current_a"`
+	Duration    float64   `json:"duration_ms"`
+	OverBudget  bool      `json:"over_budget"`
+}
+
+// estimate works out the cost of a frame that flips the given number of dots.
+func (c PowerConfig) estimate(flips int) powerEstimate {
+	e := powerEstimate{Flips: flips, Energy: float64(flips) * c.CoilEnergy}
+
+	parallel := flips
+	if parallel > c.DriverParallelism {
+		parallel = c.DriverParallelism
+	}
+	e.PeakCurrent = float64(parallel) * c.CoilCurrent
+	if c.DriverParallelism > 0 {
+		pulses := (flips + c.DriverParallelism - 1) / c.DriverParallelism
+		e.Duration = float64(time.Duration(pulses)*c.PulseDuration) / float64(time.Millisecond)
+	}
+
+	e.OverBudget = (c.MaxFrameEnergy > 0 && e.Energy > c.MaxFrameEnergy) ||
+		(c.MaxPeakCurrent > 0 && e.PeakCurrent > c.MaxPeakCurrent)
+	return e
+}
+
+// powerHistoryLength is how many frame estimates are kept for the graph.
+const powerHistoryLength = 300
+
+var (
+	powerHistory      []powerEstimate
+	powerOverBudget   uint64
+	powerHistoryMutex sync.Mutex
+)
+
+// recordFramePower estimates the cost of a frame that flipped the given
+// number of dots, warning when it is over budget.
+func recordFramePower(flips int) {
+	e := currentConfig().Power.estimate(flips)
+	e.Timestamp = simClock.Now()
+
+	if e.OverBudget {
+		log.Warnf("Frame over power budget: %d flips need an estimated %.0f mJ with a %.2f A peak",
+			e.Flips, e.Energy, e.PeakCurrent)
+	}
+
+	powerHistoryMutex.Lock()
+	defer powerHistoryMutex.Unlock()
+	if e.OverBudget {
+		powerOverBudget++
+	}
+	powerHistory = append(powerHistory, e)
+	if len(powerHistory) > powerHistoryLength {
+		powerHistory = powerHistory[len(powerHistory)-powerHistoryLength:]
+	}
+}
+
+// recentPower returns a copy of the recent frame estimates, oldest first,
+// and the number of frames over budget so far.
+func recentPower() ([]powerEstimate, uint64) {
+	powerHistoryMutex.Lock()
+	defer powerHistoryMutex.Unlock()
+	return append([]powerEstimate(nil), powerHistory...), powerOverBudget
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPowerEstimate(t *testing.T) {
+	model := PowerConfig{
+		CoilEnergy:        10,
+		CoilCurrent:       0.5,
+		PulseDuration:     time.Millisecond,
+		DriverParallelism: 8,
+		MaxFrameEnergy:    500,
+	}
+
+	e := model.estimate(20)
+	if e.Energy != 200 || e.PeakCurrent != 4 || e.Duration != 3 || e.OverBudget {
+		t.Errorf("Unexpected estimate for 20 flips: %+v", e)
+	}
+
+	e = model.estimate(3)
+	if e.PeakCurrent != 1.5 || e.Duration != 1 {
+		t.Errorf("Expected fewer flips than drivers to pulse together, got %+v", e)
+	}
+
+	if e = model.estimate(51); !e.OverBudget {
+		t.Errorf("Expected 510 mJ to exceed the 500 mJ budget, got %+v", e)
+	}
+
+	model.MaxPeakCurrent = 2
+	if e = model.estimate(5); !e.OverBudget {
+		t.Errorf("Expected a 2.5 A peak to exceed the 2 A budget, got %+v", e)
+	}
+}
+
+func TestRecordFramePower(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Power.MaxFrameEnergy = 100
+	withConfig(t, cfg)
+	powerHistory, powerOverBudget = nil, 0
+
+	for i := 0; i < powerHistoryLength+5; i++ {
+		recordFramePower(i % 20)
+	}
+
+	frames, overBudget := recentPower()
+	if len(frames) != powerHistoryLength {
+		t.Errorf("Expected %d estimates to be kept, got %d", powerHistoryLength, len(frames))
+	}
+	// Frames with 11 to 19 flips exceed 100 mJ at 10 mJ per flip.
+	if want := uint64(9 * 15); overBudget != want {
+		t.Errorf("Expected %d frames over budget, got %d", want, overBudget)
+	}
+}
+
+func TestPowerEndpoint(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Power.MaxFrameEnergy = 250
+	withConfig(t, cfg)
+	powerHistory, powerOverBudget = nil, 0
+	recordFramePower(30)
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/power", nil))
+	var body struct {
+		Model      map[string]interface{} `json:"model"`
+		Frames     []powerEstimate        `json:"frames"`
+		OverBudget uint64                 `json:"over_budget"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid /power response: %v", err)
+	}
+	// The web UI scales its graph by the budget.
+	if budget, ok := body.Model["max_frame_energy_mj"].(float64); !ok || budget != 250 {
+		t.Errorf("Expected model.max_frame_energy_mj to be 250, got %v", body.Model)
+	}
+	if len(body.Frames) != 1 || body.OverBudget != 1 {
+		t.Errorf("Expected one frame over budget, got %d frames and %d over", len(body.Frames), body.OverBudget)
+	}
+}
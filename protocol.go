@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Protocols the simulator can decode, selected with the protocol setting.
+const (
+	protocolHanover  = "hanover"
+	protocolAlfaZeta = "alfazeta"
+	protocolIBIS     = "ibis"
+)
+
+// protocols creates a decoder for each protocol.
+var protocols = map[string]func() protocolDecoder{
+	protocolHanover:  func() protocolDecoder { return hanoverDecoder{} },
+	protocolAlfaZeta: func() protocolDecoder { return &alfaZetaDecoder{} },
+	protocolIBIS:     func() protocolDecoder { return &ibisDecoder{} },
+}
+
+// protocolAliases are other names accepted for a protocol. Brose and
+// Luminator destination signs are driven over IBIS.
+var protocolAliases = map[string]string{
+	"alfa-zeta": protocolAlfaZeta,
+	"xy5":       protocolAlfaZeta,
+	"brose":     protocolIBIS,
+	"luminator": protocolIBIS,
+}
+
+// protocolDecoder splits the bytes received from the serial port into
+// packets for one display protocol and turns each packet into a frame.
+type protocolDecoder interface {
+	// name returns the protocol's name.
+	name() string
+	// split returns the complete packets at the start of buf and whatever is
+	// left over to wait for more data.
+	split(buf []byte) (packets [][]byte, rest []byte)
+	// decode returns the frame a complete packet shows, or a nil frame if
+	// the packet changes nothing visible, and whether its checksum matched.
+	// Packets the display would ignore are rejected with a *packetError.
+	decode(data []byte) (frame [][]bool, checksumOK bool, err error)
+	// fields splits a complete packet into its fields without decoding it.
+	fields(data []byte) (*decodedPacket, error)
+	// describe summarises a packet on one line.
+	describe(data []byte) string
+}
+
+// packetError rejects a packet, with the reason it is counted under in
+// hanover_packets_rejected_total.
+type packetError struct {
+	reason  string
+	message string
+}
+
+func (e *packetError) Error() string {
+	return e.message
+}
+
+func rejectPacket(reason, format string, args ...interface{}) error {
+	return &packetError{reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+// lookupProtocol returns the canonical name of a protocol, which defaults to
+// Hanover, or protocolAuto.
+func lookupProtocol(name string) (string, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		return protocolHanover, nil
+	}
+	if name == protocolAuto {
+		return protocolAuto, nil
+	}
+	if alias, ok := protocolAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := protocols[name]; !ok {
+		return "", fmt.Errorf("unknown protocol %q, expected one of %s", name, strings.Join(protocolNames(), ", "))
+	}
+	return name, nil
+}
+
+// protocolNames lists the protocols, their aliases and auto.
+func protocolNames() []string {
+	names := []string{protocolAuto}
+	for name := range protocols {
+		names = append(names, name)
+	}
+	for name := range protocolAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	decoder      protocolDecoder
+	decoderMutex sync.Mutex
+)
+
+// activeDecoder returns the decoder for the protocols the sign's panels
+// speak, as configured or detected, starting a new one when they have
+// changed.
+func activeDecoder() protocolDecoder {
+	names := signProtocols()
+	name := strings.Join(names, "+")
+	decoderMutex.Lock()
+	defer decoderMutex.Unlock()
+	if decoder == nil || decoder.name() != name {
+		if len(names) == 1 {
+			decoder = protocols[name]()
+		} else {
+			decoder = newMixedDecoder(names)
+		}
+	}
+	return decoder
+}
+
+// mixedDecoder decodes the line of a sign whose panels speak different
+// protocols, handing each packet to the decoder of its protocol. Hanover
+// and Alfa-Zeta packets are told apart by their start bytes; anything else
+// up to a carriage return is an IBIS telegram.
+type mixedDecoder struct {
+	names    []string
+	decoders map[string]protocolDecoder
+}
+
+func newMixedDecoder(names []string) *mixedDecoder {
+	d := &mixedDecoder{names: names, decoders: make(map[string]protocolDecoder)}
+	for _, name := range names {
+		d.decoders[name] = protocols[name]()
+	}
+	return d
+}
+
+func (d *mixedDecoder) name() string { return strings.Join(d.names, "+") }
+
+// startBytes are the bytes packets of each framed protocol begin with.
+var startBytes = map[string]byte{
+	protocolHanover:  hanoverSTX,
+	protocolAlfaZeta: alfaZetaStart,
+}
+
+// nextStart returns where the first framed packet in buf starts and the
+// decoder for it, or -1 if there is none.
+func (d *mixedDecoder) nextStart(buf []byte) (int, protocolDecoder) {
+	first, owner := -1, protocolDecoder(nil)
+	for name, start := range startBytes {
+		if d.decoders[name] == nil {
+			continue
+		}
+		if i := bytes.IndexByte(buf, start); i != -1 && (first == -1 || i < first) {
+			first, owner = i, d.decoders[name]
+		}
+	}
+	return first, owner
+}
+
+func (d *mixedDecoder) split(buf []byte) ([][]byte, []byte) {
+	var packets [][]byte
+	ibis := d.decoders[protocolIBIS] != nil
+	for len(buf) > 0 {
+		start, framed := d.nextStart(buf)
+		if end := bytes.IndexByte(buf, ibisEnd); ibis && end != -1 && (start == -1 || end < start) {
+			if len(buf) < end+2 {
+				return packets, buf
+			}
+			packets = append(packets, buf[:end+2])
+			buf = buf[end+2:]
+			continue
+		}
+		if start == -1 {
+			if ibis {
+				return packets, buf
+			}
+			return packets, nil
+		}
+		// Take only the first packet, as what follows it may be another
+		// protocol's.
+		found, rest := framed.split(buf[start:])
+		if len(found) == 0 {
+			return packets, rest
+		}
+		packets = append(packets, found[0])
+		buf = buf[start+bytes.Index(buf[start:], found[0])+len(found[0]):]
+	}
+	return packets, buf
+}
+
+// decoderFor returns the decoder for a packet split from the line.
+func (d *mixedDecoder) decoderFor(data []byte) protocolDecoder {
+	for name, start := range startBytes {
+		if decoder := d.decoders[name]; decoder != nil && len(data) > 0 && data[0] == start {
+			return decoder
+		}
+	}
+	if decoder := d.decoders[protocolIBIS]; decoder != nil {
+		return decoder
+	}
+	return nil
+}
+
+func (d *mixedDecoder) decode(data []byte) ([][]bool, bool, error) {
+	decoder := d.decoderFor(data)
+	if decoder == nil {
+		return nil, true, rejectPacket(rejectFraming, "Packet is not for any protocol of this display")
+	}
+	return decoder.decode(data)
+}
+
+func (d *mixedDecoder) fields(data []byte) (*decodedPacket, error) {
+	decoder := d.decoderFor(data)
+	if decoder == nil {
+		return nil, fmt.Errorf("packet is not for any protocol of this display")
+	}
+	return decoder.fields(data)
+}
+
+func (d *mixedDecoder) describe(data []byte) string {
+	decoder := d.decoderFor(data)
+	if decoder == nil {
+		return "packet for none of the display's protocols"
+	}
+	return decoder.name() + "  " + decoder.describe(data)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withProtocol installs cfg with a fresh decoder and no partial packet.
+func withProtocol(t *testing.T, cfg Config) {
+	t.Helper()
+	withConfig(t, cfg)
+	decoder, partialPacket = nil, nil
+	t.Cleanup(func() { decoder, partialPacket = nil, nil })
+}
+
+func alfaZetaPacket(command, address byte, columns []byte) []byte {
+	packet := []byte{alfaZetaStart, command, address}
+	packet = append(packet, columns...)
+	return append(packet, alfaZetaEnd)
+}
+
+func ibisTelegram(text string) []byte {
+	telegram := append([]byte(text), ibisEnd)
+	return append(telegram, ibisParity(telegram))
+}
+
+func TestLookupProtocol(t *testing.T) {
+	for name, want := range map[string]string{"": protocolHanover, "Hanover": protocolHanover, "xy5": protocolAlfaZeta, "brose": protocolIBIS, "luminator": protocolIBIS} {
+		if got, err := lookupProtocol(name); err != nil || got != want {
+			t.Errorf("lookupProtocol(%q) = %q, %v; expected %q", name, got, err, want)
+		}
+	}
+	if _, err := lookupProtocol("morse"); err == nil {
+		t.Error("Expected an unknown protocol to be rejected")
+	}
+}
+
+func TestAlfaZetaDecoder(t *testing.T) {
+	withProtocol(t, Config{Columns: 28, Rows: 7, Address: 1, Protocol: protocolAlfaZeta})
+
+	columns := make([]byte, 28)
+	columns[0], columns[27] = 0x01, 0x40
+	packet := alfaZetaPacket(0x83, 1, columns)
+
+	// Noise, then a cut-off packet, then the packet split across two reads.
+	stream := append([]byte{0x10, alfaZetaStart, 0x83, 0x01, 0x7F}, packet...)
+	if got := reassemblePacket(stream[:10]); len(got) != 0 {
+		t.Fatalf("Expected no packets yet, got %v", got)
+	}
+	got := reassemblePacket(stream[10:])
+	if len(got) != 1 || !bytes.Equal(got[0], packet) {
+		t.Fatalf("Expected the complete packet, got %v", got)
+	}
+
+	parseData(got[0])
+	if !display.pixels[0][0] || !display.pixels[6][27] || countUpdatedPixels() != 2 {
+		t.Errorf("Expected the top left and bottom right dots to be set, got %d set", countUpdatedPixels())
+	}
+
+	// Commands without a refresh wait for 0x82.
+	full := bytes.Repeat([]byte{0x7F}, 28)
+	parseData(alfaZetaPacket(0x84, alfaZetaBroadcast, full))
+	if countUpdatedPixels() != 2 {
+		t.Errorf("Expected the display to wait for a refresh, got %d set", countUpdatedPixels())
+	}
+	parseData([]byte{alfaZetaStart, alfaZetaRefresh, alfaZetaBroadcast, alfaZetaEnd})
+	if countUpdatedPixels() != 28*7 {
+		t.Errorf("Expected every dot set after the refresh, got %d", countUpdatedPixels())
+	}
+
+	if _, _, err := activeDecoder().decode(alfaZetaPacket(0x83, 2, columns)); err == nil {
+		t.Error("Expected a packet for another address to be rejected")
+	}
+	if _, _, err := activeDecoder().decode(alfaZetaPacket(0x83, 1, columns[:5])); err == nil {
+		t.Error("Expected a packet of the wrong length to be rejected")
+	}
+}
+
+func TestAlfaZetaAddresses(t *testing.T) {
+	// XY5 panels take any address up to 0xFE, unlike Hanover's single digit.
+	cfg := defaultConfig()
+	cfg.Protocol, cfg.Columns, cfg.Rows, cfg.Address = "xy5", 28, 7, 0x2A
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected address 42 to be valid for alfazeta, got %v", err)
+	}
+	cfg.Address = alfaZetaBroadcast
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected the broadcast address to be rejected as the panel's address")
+	}
+	cfg.Protocol, cfg.Address = protocolHanover, 0x2A
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected address 42 to be rejected for hanover")
+	}
+
+	withProtocol(t, Config{Columns: 28, Rows: 7, Address: 0x2A, Protocol: protocolAlfaZeta})
+	if _, _, err := activeDecoder().decode(alfaZetaPacket(0x83, 0x2A, make([]byte, 28))); err != nil {
+		t.Errorf("Expected a packet for address 42 to be accepted, got %v", err)
+	}
+}
+
+func TestIBISDecoder(t *testing.T) {
+	withProtocol(t, Config{Columns: 96, Rows: 16, Address: 1, Protocol: "brose"})
+
+	stream := append(ibisTelegram("l012"), ibisTelegram("zA1Zoo             ")...)
+	packets := reassemblePacket(append(stream, 'z', 'A'))
+	if len(packets) != 2 || len(partialPacket) != 2 {
+		t.Fatalf("Expected two telegrams and a partial one, got %q and %q", packets, partialPacket)
+	}
+	for _, packet := range packets {
+		parseData(packet)
+	}
+
+	font, _ := lookupFont(defaultFont)
+	want := newFrame(16, 96)
+	y := (16 - font.glyphHeight()) / 2
+	blitFrame(want, font.renderText("12"), 0, y)
+	x := font.textWidth("12") + 2*font.glyphWidth()
+	blitFrame(want, font.renderText("Zoo"), x+(96-x-font.textWidth("Zoo"))/2, y)
+	if got := snapshotDisplay(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected display:\n%s", strings.Join(renderFrame(got, tuiModeDots), "\n"))
+	}
+
+	frame, checksumOK, err := activeDecoder().decode([]byte("aA1\r\x00"))
+	if frame != nil || checksumOK || err != nil {
+		t.Errorf("Expected a telegram for another device with bad parity to be ignored, got %v, %v", checksumOK, err)
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	withConfig(t, Config{Columns: 28, Rows: 7, Address: 1})
+
+	hanover := hanoverPacket(t, 1, newFrame(7, 28))
+	alfaZeta := alfaZetaPacket(0x83, 1, make([]byte, 28))
+	ibis := append(ibisTelegram("l012"), ibisTelegram("zA1Zoo             ")...)
+	binary := append([]byte{hanoverSTX, '1', '1', '1', 'C', 0xFF, 0x80}, hanoverETX, '0', '0')
+	noise := bytes.Repeat([]byte{0xF0, 0x0F, 0xE7}, 40)
+
+	for _, tc := range []struct {
+		name   string
+		sample []byte
+		want   string
+	}{
+		// Starting part way through a packet must not spoil the guess.
+		{"hanover", append(hanover[10:], bytes.Repeat(hanover, 3)...), protocolHanover},
+		{"alfazeta", bytes.Repeat(alfaZeta, 3), protocolAlfaZeta},
+		{"ibis", ibis, protocolIBIS},
+		{"binary", bytes.Repeat(binary, 3), protocolHanoverBinary},
+	} {
+		guess, _ := detectProtocol(tc.sample)
+		if guess.protocol != tc.want || guess.confidence < detectConfidence {
+			t.Errorf("%s: detected %s with %.2f confidence", tc.name, guess.protocol, guess.confidence)
+		}
+	}
+
+	guess, note := detectProtocol(noise)
+	if guess.confidence >= 0.5 || !strings.Contains(note, "baud rate") {
+		t.Errorf("Expected noise to suggest a baud rate mismatch, got %+v, %q", guess, note)
+	}
+}
+
+func TestAutoProtocol(t *testing.T) {
+	withProtocol(t, Config{Columns: 28, Rows: 7, Address: 1, Protocol: "auto"})
+	t.Cleanup(func() { detection, autoProtocol = ProtocolStatus{}, "" })
+
+	if name := activeDecoder().name(); name != protocolHanover {
+		t.Fatalf("Expected Hanover until a protocol is detected, got %s", name)
+	}
+	columns := make([]byte, 28)
+	columns[0] = 0x01
+	packet := alfaZetaPacket(0x83, 1, columns)
+
+	detector := &protocolDetector{}
+	detector.observe(packet)
+	if name := activeDecoder().name(); name != protocolHanover {
+		t.Fatalf("Expected one packet not to be enough, switched to %s", name)
+	}
+	detector.observe(packet)
+	status := getProtocolStatus()
+	if status.Active != protocolAlfaZeta || status.Detected != protocolAlfaZeta || status.Packets != 2 {
+		t.Fatalf("Expected Alfa-Zeta to be detected and selected, got %+v", status)
+	}
+
+	for _, p := range reassemblePacket(packet) {
+		parseData(p)
+	}
+	if !display.pixels[0][0] {
+		t.Error("Expected the packet after detection to be decoded as Alfa-Zeta")
+	}
+}
+
+func TestMixedProtocolPanels(t *testing.T) {
+	withProtocol(t, Config{Columns: 66, Rows: 7, Address: 1, Panels: []PanelConfig{
+		{Address: 1, X: 0, Columns: 8, Rows: 7},
+		{Address: 0x2A, X: 8, Columns: 28, Rows: 7, Protocol: "xy5"},
+		{Address: 0, X: 36, Columns: 30, Rows: 7, Protocol: protocolIBIS},
+	}})
+
+	if name := activeDecoder().name(); name != "alfazeta+hanover+ibis" {
+		t.Fatalf("Expected a decoder for all three protocols, got %s", name)
+	}
+
+	hanover := newFrame(7, 8)
+	hanover[0][0] = true
+	columns := make([]byte, 28)
+	columns[0] = 0x01
+	stream := append(hanoverPacket(t, 1, hanover), ibisTelegram("l007")...)
+	stream = append(stream, alfaZetaPacket(0x83, 0x2A, columns)...)
+	// A packet for the Hanover address on the Alfa-Zeta panel goes nowhere.
+	stream = append(stream, alfaZetaPacket(0x83, 1, bytes.Repeat([]byte{0x7F}, 28))...)
+
+	packets := reassemblePacket(stream[:20])
+	packets = append(packets, reassemblePacket(stream[20:])...)
+	if len(packets) != 4 {
+		t.Fatalf("Expected four packets, got %q", packets)
+	}
+	for _, packet := range packets {
+		parseData(packet)
+	}
+
+	want := newFrame(7, 66)
+	want[0][0] = true
+	want[0][8] = true
+	ibis := (&ibisDecoder{line: "7"}).renderPanel(7, 30)
+	for row := range ibis {
+		copy(want[row][36:], ibis[row])
+	}
+	if got := snapshotDisplay(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected display:\n%s", strings.Join(renderFrame(got, tuiModeDots), "\n"))
+	}
+
+	if packets, err := encodeSignPackets(want); err != nil || len(packets) != 1 || packets[0][2] != '1' {
+		t.Errorf("Expected a packet for the Hanover panel only, got %q, %v", packets, err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Columns, cfg.Rows = 66, 7
+	cfg.Panels = currentConfig().Panels
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected the mixed sign to be valid, got %v", err)
+	}
+	cfg.Panels = append([]PanelConfig(nil), cfg.Panels...)
+	cfg.Panels[1].Protocol = protocolHanover
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "panels[1].address must be between 1 and 9") {
+		t.Errorf("Expected address 42 to be rejected for a Hanover panel, got %v", err)
+	}
+	cfg.Panels[1].Protocol = protocolAuto
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "panels[1].protocol cannot be auto") {
+		t.Errorf("Expected auto to be rejected as a panel protocol, got %v", err)
+	}
+}
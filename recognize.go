@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RecognizedLine is a line of text read off the display, with the position
+// of its first character and the font it matched.
+type RecognizedLine struct {
+	Text string `json:"text"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Font string `json:"font"`
+	// Confidence is the share of the line's lit dots (0 to 1) that the
+	// recognised characters account for.
+	Confidence float64 `json:"confidence"`
+}
+
+// RecognizedText is the text read off the display, top to bottom, with
+// lines joined by newlines.
+type RecognizedText struct {
+	Text  string           `json:"text"`
+	Lines []RecognizedLine `json:"lines"`
+}
+
+// glyphCell is a character as drawn by a font, for matching against the
+// display.
+type glyphCell struct {
+	char   rune
+	pixels [][]bool
+}
+
+// fontCells renders every glyph of font, ordered so that the space comes
+// first and ties between identical glyphs always resolve the same way.
+func fontCells(font *bitmapFont) []glyphCell {
+	chars := make([]rune, 0, len(font.glyphs))
+	for r := range font.glyphs {
+		chars = append(chars, r)
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+	cells := make([]glyphCell, len(chars))
+	for i, r := range chars {
+		cells[i] = glyphCell{char: r, pixels: font.renderText(string(r))}
+	}
+	return cells
+}
+
+// recognizeText reads text off frame by matching it against the glyphs of
+// the named font, or of every font if font is empty, taking the best fit for
+// each line. Characters that match no glyph well are read as '?'.
+func recognizeText(frame [][]bool, font string) (RecognizedText, error) {
+	var candidates []*bitmapFont
+	if font != "" {
+		f, err := lookupFont(font)
+		if err != nil {
+			return RecognizedText{}, err
+		}
+		candidates = append(candidates, f)
+	} else {
+		names := make([]string, 0, len(fonts))
+		for name := range fonts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			candidates = append(candidates, fonts[name])
+		}
+	}
+
+	// Read the display with each font and keep the reading that leaves the
+	// fewest dots unexplained.
+	var best []RecognizedLine
+	bestMisses := -1
+	for _, f := range candidates {
+		lines, misses := recognizeLines(frame, f)
+		if bestMisses == -1 || misses < bestMisses {
+			best, bestMisses = lines, misses
+		}
+	}
+
+	result := RecognizedText{Lines: best}
+	if result.Lines == nil {
+		result.Lines = []RecognizedLine{}
+	}
+	texts := make([]string, len(best))
+	for i, line := range best {
+		texts[i] = line.Text
+	}
+	result.Text = strings.Join(texts, "\n")
+	return result, nil
+}
+
+// recognizeLines reads every line of text in frame with one font. It also
+// returns how many dots the reading does not explain: dots that differ from
+// the recognised characters and lit dots outside every line.
+func recognizeLines(frame [][]bool, font *bitmapFont) ([]RecognizedLine, int) {
+	rows := len(frame)
+	height := font.glyphHeight()
+	unexplained := 0
+	for row := range frame {
+		unexplained += litIn(frame, row, 1, 0, len(frame[row]))
+	}
+	if rows < height {
+		return nil, unexplained
+	}
+	cells := fontCells(font)
+
+	var lines []RecognizedLine
+	for top := 0; top < rows; {
+		if !rowLit(frame[top]) {
+			top++
+			continue
+		}
+		// A band of lit rows no taller than a character. Shorter bands
+		// can sit anywhere in the character cell, so try every position.
+		bottom := top
+		for bottom+1 < rows && bottom+1 < top+height && rowLit(frame[bottom+1]) {
+			bottom++
+		}
+		var line *RecognizedLine
+		lineMisses, lineLit := 0, 0
+		for y := bottom - height + 1; y <= top; y++ {
+			if y < 0 || y+height > rows {
+				continue
+			}
+			candidate, misses, lit := recognizeLine(frame, font, cells, y)
+			if lit > 0 && (line == nil || candidate.Confidence > line.Confidence) {
+				line, lineMisses, lineLit = &candidate, misses, lit
+			}
+		}
+		if line != nil && line.Text != "" {
+			lines = append(lines, *line)
+			unexplained += lineMisses - lineLit
+		}
+		top = bottom + 1
+	}
+	return lines, unexplained
+}
+
+func rowLit(row []bool) bool {
+	for _, on := range row {
+		if on {
+			return true
+		}
+	}
+	return false
+}
+
+// recognizeLine reads the line of characters whose cells start at row y,
+// returning it with the number of dots that differ from it and the number of
+// lit dots in its rows. Runs of characters are read at the font's fixed
+// pitch; after a gap the next run is lined up afresh, so separately placed
+// words are read too.
+func recognizeLine(frame [][]bool, font *bitmapFont, cells []glyphCell, y int) (RecognizedLine, int, int) {
+	columns := len(frame[0])
+	width, pitch := font.glyphWidth(), font.glyphWidth()+font.scale
+	lit := litIn(frame, y, font.glyphHeight(), 0, columns)
+	if lit == 0 {
+		return RecognizedLine{}, 0, 0
+	}
+
+	line := RecognizedLine{Y: y, Font: font.name, X: -1}
+	var text strings.Builder
+	misses := 0
+	gap := -1 // where the blank cell before the current run began
+	for x := nextLitColumn(frame, y, font.glyphHeight(), 0); x < columns; {
+		// Line the cell up so the lit column falls inside it, preferring
+		// the offset that fits this character and the next best.
+		start, bestCost := x, -1
+		for s := x - width + 1; s <= x; s++ {
+			_, cost := matchCell(frame, cells, y, s)
+			_, next := matchCell(frame, cells, y, s+pitch)
+			if bestCost == -1 || cost+next < bestCost {
+				start, bestCost = s, cost+next
+			}
+		}
+		if gap >= 0 && line.X != -1 {
+			spaces := (start - gap + pitch/2) / pitch
+			if spaces < 1 {
+				spaces = 1
+			}
+			text.WriteString(strings.Repeat(" ", spaces))
+		}
+
+		s := start
+		for ; s < columns; s += pitch {
+			char, cost := matchCell(frame, cells, y, s)
+			if char == ' ' {
+				break
+			}
+			misses += cost
+			if cost > 0 && cost*4 > cellLit(cells, char) {
+				char = '?'
+			}
+			if line.X == -1 {
+				line.X = s
+			}
+			text.WriteRune(char)
+			// Dots in the spacing between characters are not part of any.
+			misses += litIn(frame, y, font.glyphHeight(), s+width, s+pitch)
+		}
+		if s == start {
+			// Stray dots that look more like a gap than any character.
+			misses += litIn(frame, y, font.glyphHeight(), start, start+width)
+			s = start + width
+		}
+		gap = s
+		x = nextLitColumn(frame, y, font.glyphHeight(), s)
+	}
+
+	line.Text = strings.TrimRight(text.String(), " ")
+	line.Confidence = 1 - float64(misses)/float64(lit)
+	if line.Confidence < 0 {
+		line.Confidence = 0
+	}
+	return line, misses, lit
+}
+
+// matchCell finds the glyph closest to the cell with its top-left corner at
+// (x, y), returning it and how many dots differ. Parts of the cell off the
+// display are not compared.
+func matchCell(frame [][]bool, cells []glyphCell, y, x int) (rune, int) {
+	best, bestCost := ' ', -1
+	for _, cell := range cells {
+		cost := 0
+		for row := range cell.pixels {
+			for col, on := range cell.pixels[row] {
+				c := x + col
+				if c < 0 || c >= len(frame[y+row]) {
+					continue
+				}
+				if frame[y+row][c] != on {
+					cost++
+				}
+			}
+		}
+		if bestCost == -1 || cost < bestCost {
+			best, bestCost = cell.char, cost
+		}
+	}
+	return best, bestCost
+}
+
+func cellLit(cells []glyphCell, char rune) int {
+	for _, cell := range cells {
+		if cell.char == char {
+			n := 0
+			for _, row := range cell.pixels {
+				for _, on := range row {
+					if on {
+						n++
+					}
+				}
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// nextLitColumn returns the first column from x on with a lit dot in rows
+// y to y+height, or the width of the frame if there is none.
+func nextLitColumn(frame [][]bool, y, height, x int) int {
+	if x < 0 {
+		x = 0
+	}
+	for ; x < len(frame[y]); x++ {
+		if litIn(frame, y, height, x, x+1) > 0 {
+			return x
+		}
+	}
+	return len(frame[y])
+}
+
+// litIn counts the lit dots in rows y to y+height and columns from to to.
+func litIn(frame [][]bool, y, height, from, to int) int {
+	n := 0
+	for row := y; row < y+height; row++ {
+		for col := from; col < to; col++ {
+			if col >= 0 && col < len(frame[row]) && frame[row][col] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// String describes the recognised text for logs and the CLI.
+func (t RecognizedText) String() string {
+	var b strings.Builder
+	for _, line := range t.Lines {
+		fmt.Fprintf(&b, "%q at %d,%d in %s (%.0f%%)\n", line.Text, line.X, line.Y, line.Font, line.Confidence*100)
+	}
+	return b.String()
+}
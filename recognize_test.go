@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecognizeText(t *testing.T) {
+	for _, tc := range []struct {
+		text, font string
+		columns    int
+		rows       int
+	}{
+		{"NEXT TRAIN 3 MIN", "5x7", 96, 16},
+		{"Platform 2: 14:05", "5x7", 112, 7},
+		{"HANOVER", "10x14", 96, 16},
+		{"A  B", "5x7", 40, 8},
+	} {
+		font, _ := lookupFont(tc.font)
+		frames, err := textEffectFrames(tc.text, font, effectStatic, tc.columns, tc.rows, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := recognizeText(frames[0], "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Text != tc.text || len(result.Lines) != 1 || result.Lines[0].Font != tc.font || result.Lines[0].Confidence != 1 {
+			t.Errorf("Expected to read %q in %s, got %q: %s", tc.text, tc.font, result.Text, result)
+		}
+	}
+}
+
+func TestRecognizeTextLayout(t *testing.T) {
+	font, _ := lookupFont("5x7")
+	frame := newFrame(16, 60)
+	blitFrame(frame, font.renderText("LINE 1"), 3, 0)
+	blitFrame(frame, font.renderText("GATE"), 2, 9)
+	// Placed separately, off the first word's character grid.
+	blitFrame(frame, font.renderText("B7"), 40, 9)
+	result, err := recognizeText(frame, "5x7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "LINE 1\nGATE  B7" {
+		t.Errorf("Unexpected text %q: %s", result.Text, result)
+	}
+	if line := result.Lines[1]; line.X != 2 || line.Y != 9 {
+		t.Errorf("Expected the second line at 2,9, got %d,%d", line.X, line.Y)
+	}
+
+	// Text scrolling off the left edge is read from what is visible.
+	frame = newFrame(7, 30)
+	blitFrame(frame, font.renderText("HELLO"), -3, 0)
+	if result, _ := recognizeText(frame, "5x7"); result.Text != "HELLO" || result.Lines[0].X != -3 {
+		t.Errorf("Expected the scrolled text to be read, got %q: %s", result.Text, result)
+	}
+
+	// A damaged character is read as '?' and lowers the confidence.
+	frame = newFrame(7, 30)
+	blitFrame(frame, font.renderText("ABC"), 0, 0)
+	for row := 0; row < 7; row++ {
+		frame[row][7] = !frame[row][7]
+	}
+	result, _ = recognizeText(frame, "5x7")
+	if result.Text != "A?C" || result.Lines[0].Confidence >= 1 {
+		t.Errorf("Expected the damaged B to be unreadable, got %q: %s", result.Text, result)
+	}
+
+	if result, _ := recognizeText(newFrame(16, 96), ""); result.Text != "" || len(result.Lines) != 0 {
+		t.Errorf("Expected no text on a blank display, got %q", result.Text)
+	}
+	if _, err := recognizeText(frame, "comic-sans"); err == nil {
+		t.Error("Expected an unknown font to be rejected")
+	}
+}
+
+func TestDisplayTextEndpoint(t *testing.T) {
+	withConfig(t, Config{Columns: 96, Rows: 16, Address: 1})
+	font, _ := lookupFont("5x7")
+	frames, _ := textEffectFrames("NEXT TRAIN 3 MIN", font, effectStatic, 96, 16, 0)
+	applyFrame(frames[0])
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/display/text", nil))
+	var result RecognizedText
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected recognised text, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result.Text != "NEXT TRAIN 3 MIN" {
+		t.Errorf("Expected the sign to say NEXT TRAIN 3 MIN, got %q", result.Text)
+	}
+
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/display/text?font=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown font to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often the configuration file is checked for
+// changes.
+const configPollInterval = 2 * time.Second
+
+// reloadFlags holds the command-line flags the simulator was started with,
+// so reloads keep the same file and overrides.
+var reloadFlags *configFlags
+
+// reloadMutex stops reloads from the file watcher, SIGHUP and POST /config
+// from switching over at the same time.
+var reloadMutex sync.Mutex
+
+// reloadConfig re-reads the configuration file and applies it.
+func reloadConfig() error {
+	if reloadFlags == nil {
+		return fmt.Errorf("no configuration file to reload")
+	}
+	cfg, err := reloadFlags.read()
+	if err != nil {
+		return err
+	}
+	return applyConfig(cfg)
+}
+
+// applyConfig switches the running simulator over to cfg: the display is
+// resized, the serial port, web listener and bridge are reopened and the
+// playlist is restarted if their settings changed. The listener and bridge
+// are opened before anything is switched over, so a failure leaves the old
+// configuration running; the serial port reconnects on its own until the
+// new one becomes available. Changes to history, wear, ui or metrics are
+// rejected.
+func applyConfig(cfg Config) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	old := currentConfig()
+
+	// These are set up once at start-up; reporting them as applied would
+	// leave the simulator running with settings other than it claims.
+	if cfg.History != old.History || cfg.Wear != old.Wear || cfg.UI != old.UI || cfg.Metrics != old.Metrics {
+		return fmt.Errorf("history, wear, ui and metrics cannot be changed while the simulator is running; restart it to apply them")
+	}
+
+	var listener net.Listener
+	var newBridge *bridge
+	cleanup := func() {
+		if listener != nil {
+			listener.Close()
+		}
+		if newBridge != nil {
+			newBridge.close()
+		}
+	}
+
+	var err error
+	if router != nil && cfg.WebPort != old.WebPort {
+		if listener, err = net.Listen("tcp", cfg.WebPort); err != nil {
+			cleanup()
+			return fmt.Errorf("error binding web server: %v", err)
+		}
+	}
+	bridgeChanged := cfg.Bridge != old.Bridge || cfg.Serial != old.Serial ||
+		(cfg.Bridge.BaudRate == 0 && cfg.BaudRate != old.BaudRate)
+	if bridgeChanged && cfg.Bridge.Port != "" {
+		if newBridge, err = openBridge(cfg.Bridge, cfg.BaudRate, cfg.Serial); err != nil {
+			cleanup()
+			return err
+		}
+	}
+
+	display.mu.Lock()
+	setConfig(cfg)
+	if cfg.Rows != old.Rows || cfg.Columns != old.Columns {
+		display.pixels = resizeFrame(display.pixels, cfg.Rows, cfg.Columns)
+		log.Infof("Resized display to %dx%d", cfg.Columns, cfg.Rows)
+	}
+	display.mu.Unlock()
+
+	if serialStarted() && (cfg.SerialPort != old.SerialPort || cfg.BaudRate != old.BaudRate || cfg.Serial != old.Serial) {
+		log.Infof("Switching to serial port %s at %d baud", cfg.SerialPort, cfg.BaudRate)
+		startSerialReader(cfg.SerialPort, cfg.BaudRate, cfg.Serial)
+	}
+	if cfg.PacketLog != old.PacketLog && packetLogStarted() {
+		closePacketLogging()
+		if err := initPacketLogging(cfg.PacketLog); err != nil {
+			log.Errorf("Error reopening packet log: %v", err)
+		}
+	}
+	if listener != nil {
+		serveWebListener(listener)
+	}
+	if bridgeChanged {
+		swapBridge(newBridge)
+		if newBridge != nil {
+			log.Infof("Bridging received data to %s", cfg.Bridge.Port)
+		}
+	}
+	if cfg.Playlist != old.Playlist {
+		switchPlaylist(cfg.Playlist)
+	}
+
+	notifyConfigChange()
+	log.Info("Configuration applied")
+	return nil
+}
+
+// resizeFrame returns frame resized to rows x columns, keeping the pixels in
+// the overlapping area.
+func resizeFrame(frame [][]bool, rows, columns int) [][]bool {
+	resized := newFrame(rows, columns)
+	for row := 0; row < rows && row < len(frame); row++ {
+		copy(resized[row], frame[row])
+	}
+	return resized
+}
+
+// watchConfigFile polls filename and reloads the configuration whenever its
+// modification time or size changes.
+func watchConfigFile(ctx context.Context, filename string) {
+	if filename == "" {
+		return
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		log.Warnf("Not watching config file: %v", err)
+		return
+	}
+	modTime, size := info.ModTime(), info.Size()
+
+	ticker := simClock.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+
+		log.Infof("Config file %s changed, reloading", filename)
+		if err := reloadConfig(); err != nil {
+			log.Errorf("Error reloading config: %v", err)
+		}
+	}
+}
+
+// reloadOnSignal reloads the configuration whenever SIGHUP is received.
+func reloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		log.Info("Received SIGHUP, reloading config")
+		if err := reloadConfig(); err != nil {
+			log.Errorf("Error reloading config: %v", err)
+		}
+	}
+}
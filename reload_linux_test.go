@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReloadBridgeDuringTraffic(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Columns, cfg.Rows = 8, 16
+	withConfig(t, cfg)
+	t.Cleanup(resetReassembly)
+	t.Cleanup(stopBridge)
+	packet := hanoverPacket(t, 1, newFrame(16, 8))
+	ports := []string{openPTY(t), openPTY(t)}
+
+	// Run with -race: data is forwarded while reloads replace the bridge.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := newReceiver()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				r.receive(packet, func(Packet) bool { return true })
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		cfg.Bridge.Port = ports[i%2]
+		cfg.Bridge.FixChecksum = i%4 < 2
+		if err := applyConfig(cfg); err != nil {
+			t.Fatalf("applyConfig failed: %v", err)
+		}
+		if b := currentBridge(); b == nil || b.cfg.Port != cfg.Bridge.Port || b.closed {
+			t.Fatalf("Expected an open bridge to %s after reload %d", cfg.Bridge.Port, i)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestApplyConfigResizesDisplay(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Columns, cfg.Rows = 8, 16
+	withConfig(t, cfg)
+	display.pixels[0][0] = true
+	display.pixels[15][7] = true
+
+	cfg.Columns, cfg.Rows = 4, 8
+	if err := applyConfig(cfg); err != nil {
+		t.Fatalf("applyConfig failed: %v", err)
+	}
+
+	if len(display.pixels) != 8 || len(display.pixels[0]) != 4 {
+		t.Fatalf("Expected a 4x8 display, got %dx%d", len(display.pixels[0]), len(display.pixels))
+	}
+	if !display.pixels[0][0] {
+		t.Error("Expected pixels in the overlapping area to be kept")
+	}
+	if config.Columns != 4 || config.Rows != 8 {
+		t.Errorf("Expected the new configuration to be installed, got %+v", config)
+	}
+}
+
+// configRequest builds a POST /config request from remoteAddr.
+func configRequest(body, remoteAddr string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestPostConfig(t *testing.T) {
+	withConfig(t, defaultConfig())
+	r := newRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, configRequest("rows: 8\naddress: 2\n", "127.0.0.1:40000"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if config.Rows != 8 || config.Address != 2 || len(display.pixels) != 8 {
+		t.Errorf("Expected the posted settings to be applied, got %+v", config)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, configRequest("rows: 0\n", "127.0.0.1:40000"))
+	if w.Code != http.StatusBadRequest || config.Rows != 8 {
+		t.Errorf("Expected an invalid configuration to be rejected, got %d and %+v", w.Code, config)
+	}
+}
+
+func TestPostConfigRejectsRestartOnlySettings(t *testing.T) {
+	withConfig(t, defaultConfig())
+	r := newRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, configRequest("rows: 8\nui: none\n", "127.0.0.1:40000"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "restart") {
+		t.Errorf("Expected a ui change to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if config.Rows != 16 || config.UI != defaultConfig().UI {
+		t.Errorf("Expected nothing to be applied, got %+v", config)
+	}
+}
+
+func TestReloadSwitchesPlaylist(t *testing.T) {
+	cfg := defaultConfig()
+	withConfig(t, cfg)
+	filename := filepath.Join(t.TempDir(), "playlist.yaml")
+	if err := os.WriteFile(filename, []byte("items:\n  - text: HI\n    hold: 1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startPlaylist(ctx, "")
+	t.Cleanup(func() { switchPlaylist("") })
+
+	cfg.Playlist = filename
+	if err := applyConfig(cfg); err != nil {
+		t.Fatalf("applyConfig failed: %v", err)
+	}
+	if playlistCancel == nil {
+		t.Error("Expected the reload to start the new playlist")
+	}
+	cfg.Playlist = ""
+	if err := applyConfig(cfg); err != nil {
+		t.Fatalf("applyConfig failed: %v", err)
+	}
+	if playlistCancel != nil {
+		t.Error("Expected removing the playlist to stop it")
+	}
+}
+
+func TestPostConfigOnlyFromLocalhost(t *testing.T) {
+	withConfig(t, defaultConfig())
+	r := newRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, configRequest("rows: 8\n", "192.0.2.1:40000"))
+	if w.Code != http.StatusForbidden || config.Rows != 16 {
+		t.Errorf("Expected a change from another host to be refused, got %d and %d rows", w.Code, config.Rows)
+	}
+
+	// Pages on other sites open in a local browser are refused too.
+	w = httptest.NewRecorder()
+	req := configRequest("rows: 8\n", "[::1]:40000")
+	req.Header.Set("Origin", "http://evil.example")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a cross-site request to be refused, got %d", w.Code)
+	}
+
+	cfg := defaultConfig()
+	cfg.RemoteConfig = true
+	setConfig(cfg)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, configRequest("rows: 8\n", "192.0.2.1:40000"))
+	if w.Code != http.StatusOK || config.Rows != 8 {
+		t.Errorf("Expected remote_config to allow changes from other hosts, got %d and %d rows", w.Code, config.Rows)
+	}
+}
+
+func TestReloadDuringTraffic(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Columns, cfg.Rows = 8, 16
+	withConfig(t, cfg)
+	t.Cleanup(resetReassembly)
+	packet := hanoverPacket(t, 1, newFrame(16, 8))
+
+	// Run with -race: packets arrive while the configuration changes.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := newReceiver()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				r.receive(packet, func(p Packet) bool {
+					handlePacket(p)
+					return true
+				})
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		cfg.Rows = 8 + 8*(i%2)
+		if err := applyConfig(cfg); err != nil {
+			t.Fatalf("applyConfig failed: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
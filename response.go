@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ResponseConfig makes the simulator answer the sender on the port it reads
+// from, so controllers that wait for a reply can be tested. After each
+// packet it waits Delay, then writes NAK if the packet was malformed or its
+// checksum did not match, and ACK followed by Status otherwise. Packets for
+// other addresses get no reply, as on a shared bus. Echo writes every
+// complete packet straight back as it arrives, as a half-duplex line would.
+// The bytes are given as hex.
+type ResponseConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	ACK     string        `yaml:"ack"`
+	NAK     string        `yaml:"nak"`
+	Status  string        `yaml:"status"`
+	Delay   time.Duration `yaml:"delay"`
+	Echo    bool          `yaml:"echo"`
+}
+
+// defaultResponse answers with the ASCII ACK and NAK control characters.
+func defaultResponse() ResponseConfig {
+	return ResponseConfig{ACK: "06", NAK: "15"}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c ResponseConfig) validate(problems []string) []string {
+	for _, field := range []struct{ name, value string }{{"ack", c.ACK}, {"nak", c.NAK}, {"status", c.Status}} {
+		if _, err := parseHex(field.value); err != nil {
+			problems = append(problems, fmt.Sprintf("response.%s must be hex bytes, got %q", field.name, field.value))
+		}
+	}
+	if c.Delay < 0 {
+		problems = append(problems, fmt.Sprintf("response.delay must not be negative, got %v", c.Delay))
+	}
+	return problems
+}
+
+// Kinds of response, used as the kind label of hanover_responses_total.
+const (
+	responseACK  = "ack"
+	responseNAK  = "nak"
+	responseEcho = "echo"
+)
+
+var (
+	responsePort  io.Writer
+	responseMutex sync.Mutex
+
+	// lastResponse is closed once the most recently queued delayed
+	// response has been written, nil when none is waiting.
+	lastResponse       chan struct{}
+	responseQueueMutex sync.Mutex
+)
+
+// setResponsePort sets the port responses are written to, nil while the
+// serial port is closed.
+func setResponsePort(w io.Writer) {
+	responseMutex.Lock()
+	defer responseMutex.Unlock()
+	responsePort = w
+}
+
+func writeResponse(kind string, data []byte) {
+	responseMutex.Lock()
+	defer responseMutex.Unlock()
+	if responsePort == nil || len(data) == 0 {
+		return
+	}
+	if _, err := responsePort.Write(data); err != nil {
+		log.Errorf("Error writing %s response: %v", kind, err)
+		return
+	}
+	metrics.responded(kind)
+	log.Debugf("Sent %s response % X", kind, data)
+}
+
+// queueResponse writes a response after delay without holding up packet
+// processing. Responses are written in the order they were queued, so a
+// later packet's reply never overtakes an earlier one's.
+func queueResponse(kind string, data []byte, delay time.Duration) {
+	responseQueueMutex.Lock()
+	defer responseQueueMutex.Unlock()
+	previous := lastResponse
+	if delay <= 0 && previous == nil {
+		writeResponse(kind, data)
+		return
+	}
+
+	due := simClock.Now().Add(delay)
+	done := make(chan struct{})
+	lastResponse = done
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		sleep(due.Sub(simClock.Now()))
+		writeResponse(kind, data)
+
+		responseQueueMutex.Lock()
+		if lastResponse == done {
+			lastResponse = nil
+		}
+		responseQueueMutex.Unlock()
+		close(done)
+	}()
+}
+
+// waitForResponses waits until every queued response has been written.
+func waitForResponses() {
+	responseQueueMutex.Lock()
+	pending := lastResponse
+	responseQueueMutex.Unlock()
+	if pending != nil {
+		<-pending
+	}
+}
+
+// respondToPacket answers a packet given the outcome of parsing it.
+func respondToPacket(checksumOK bool, err error) {
+	cfg := currentConfig().Response
+	if !cfg.Enabled {
+		return
+	}
+	if rejected, ok := err.(*packetError); ok && rejected.reason == rejectWrongAddress {
+		return
+	}
+
+	// The configuration was validated, so the hex is well formed.
+	kind, data := responseNAK, mustParseHex(cfg.NAK)
+	if err == nil && checksumOK {
+		kind, data = responseACK, append(mustParseHex(cfg.ACK), mustParseHex(cfg.Status)...)
+	}
+
+	queueResponse(kind, data, cfg.Delay)
+}
+
+func mustParseHex(s string) []byte {
+	data, _ := parseHex(s)
+	return data
+}
+
+// echoPacket writes a packet back as soon as it has been received, if echo
+// is enabled.
+func echoPacket(packet []byte) {
+	if cfg := currentConfig().Response; cfg.Enabled && cfg.Echo {
+		writeResponse(responseEcho, packet)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponses(t *testing.T) {
+	cfg := Config{Columns: 8, Rows: 16, Address: 1, Response: ResponseConfig{Enabled: true, ACK: "06", NAK: "15", Status: "41 30"}}
+	withProtocol(t, cfg)
+	var written bytes.Buffer
+	setResponsePort(&written)
+	defer setResponsePort(nil)
+
+	good := hanoverPacket(t, 1, newFrame(16, 8))
+	badChecksum := append(append([]byte(nil), good[:len(good)-2]...), '0', '0')
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+		want   []byte
+	}{
+		{"valid", good, []byte{0x06, 0x41, 0x30}},
+		{"bad checksum", badChecksum, []byte{0x15}},
+		{"malformed", []byte{0x02, '1', '1', 0x03, 'F', 'F', 0x00, 0x00, 0x00}, []byte{0x15}},
+		{"other address", hanoverPacket(t, 2, newFrame(16, 8)), nil},
+	} {
+		written.Reset()
+		handlePacket(Packet{Timestamp: time.Now(), Data: tc.packet, FromPort: true})
+		if !bytes.Equal(written.Bytes(), tc.want) {
+			t.Errorf("%s: expected a response of % X, got % X", tc.name, tc.want, written.Bytes())
+		}
+	}
+
+	written.Reset()
+	config.Response.Echo = true
+	echoPacket(good)
+	if !bytes.Equal(written.Bytes(), good) {
+		t.Errorf("Expected the packet to be echoed, got % X", written.Bytes())
+	}
+
+	// Packets that did not come from the port, like the start-up test
+	// packet, have no sender waiting for a reply.
+	written.Reset()
+	handlePacket(Packet{Timestamp: time.Now(), Data: good})
+	if written.Len() != 0 {
+		t.Errorf("Expected no response to an injected packet, got % X", written.Bytes())
+	}
+
+	written.Reset()
+	config.Response.Enabled = false
+	handlePacket(Packet{Timestamp: time.Now(), Data: good, FromPort: true})
+	if written.Len() != 0 {
+		t.Errorf("Expected no response when disabled, got % X", written.Bytes())
+	}
+}
+
+func TestNAKedPacketsNotShown(t *testing.T) {
+	withProtocol(t, Config{Columns: 8, Rows: 16, Address: 1, Response: ResponseConfig{Enabled: true, ACK: "06", NAK: "15"}})
+	var written bytes.Buffer
+	setResponsePort(&written)
+	defer setResponsePort(nil)
+
+	frame := newFrame(16, 8)
+	frame[0][0] = true
+	good := hanoverPacket(t, 1, frame)
+	badChecksum := append(append([]byte(nil), good[:len(good)-2]...), '0', '0')
+
+	handlePacket(Packet{Timestamp: time.Now(), Data: badChecksum, FromPort: true})
+	if !bytes.Equal(written.Bytes(), []byte{0x15}) || countUpdatedPixels() != 0 {
+		t.Errorf("Expected a NAK and a blank display, got % X and %d dots set", written.Bytes(), countUpdatedPixels())
+	}
+
+	written.Reset()
+	handlePacket(Packet{Timestamp: time.Now(), Data: good, FromPort: true})
+	if !bytes.Equal(written.Bytes(), []byte{0x06}) || !display.pixels[0][0] {
+		t.Errorf("Expected an ACK and the packet shown, got % X", written.Bytes())
+	}
+
+	// Without responses nobody resends, so the packet is shown anyway.
+	config.Response.Enabled = false
+	frame[0][0], frame[1][1] = false, true
+	bad := hanoverPacket(t, 1, frame)
+	bad[len(bad)-1] ^= 1
+	handlePacket(Packet{Timestamp: time.Now(), Data: bad, FromPort: true})
+	if display.pixels[0][0] || !display.pixels[1][1] {
+		t.Error("Expected a bad checksum to be shown with responses disabled")
+	}
+}
+
+func TestDelayedResponses(t *testing.T) {
+	cfg := Config{Columns: 8, Rows: 16, Address: 1, Response: ResponseConfig{Enabled: true, ACK: "06", NAK: "15", Delay: 50 * time.Millisecond}}
+	withProtocol(t, cfg)
+	clock := useVirtualClock(t)
+	var written bytes.Buffer
+	setResponsePort(&written)
+	defer setResponsePort(nil)
+
+	good := hanoverPacket(t, 1, newFrame(16, 8))
+	bad := append(append([]byte(nil), good[:len(good)-2]...), '0', '0')
+
+	// Processing carries on while the responses wait.
+	handlePacket(Packet{Timestamp: clock.Now(), Data: good, FromPort: true})
+	handlePacket(Packet{Timestamp: clock.Now(), Data: bad, FromPort: true})
+	responseMutex.Lock()
+	early := written.Len()
+	responseMutex.Unlock()
+	if early != 0 {
+		t.Errorf("Expected nothing written before the delay, got %d bytes", early)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+	waitForResponses()
+	if want := []byte{0x06, 0x15}; !bytes.Equal(written.Bytes(), want) {
+		t.Errorf("Expected the responses in order, % X, got % X", want, written.Bytes())
+	}
+}
+
+func TestResponseConfigValidate(t *testing.T) {
+	problems := ResponseConfig{ACK: "06", NAK: "zz", Delay: -time.Second}.validate(nil)
+	if len(problems) != 2 || !strings.Contains(problems[0], "response.nak") || !strings.Contains(problems[1], "response.delay") {
+		t.Errorf("Unexpected problems %q", problems)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Faults a scenario can apply to the data it sends.
+const (
+	faultNone            = "none"
+	faultCorruptChecksum = "corrupt_checksum" // flip a bit of the last byte
+	faultDrop            = "drop"             // lose the data entirely
+	faultTruncate        = "truncate"         // lose the second half
+	faultNoise           = "noise"            // send line noise first
+)
+
+var scenarioFaults = map[string]bool{
+	faultNone: true, faultCorruptChecksum: true, faultDrop: true, faultTruncate: true, faultNoise: true,
+}
+
+// scenarioPort is the name the scenario's simulated serial port is reported
+// under.
+const scenarioPort = "scenario"
+
+// scenario is a scripted test session. Its steps run in order, each at its
+// time from the start, feeding data into the packet pipeline as if it had
+// been read from the serial port and checking the display.
+type scenario struct {
+	Name string `yaml:"name"`
+	// Config is applied on top of the configuration, e.g. to set the
+	// display size or protocol.
+	Config map[string]interface{} `yaml:"config"`
+	Steps  []scenarioStep         `yaml:"steps"`
+}
+
+// scenarioStep does one thing at time At. Steps without a time run straight
+// after the one before.
+type scenarioStep struct {
+	At time.Duration `yaml:"at"`
+
+	// Packet is sent as is, given as hex.
+	Packet string `yaml:"packet"`
+	// Text and Image, an image or ASCII art file, are rendered for the
+	// display and sent as Hanover packets, one per panel.
+	Text  string `yaml:"text"`
+	Font  string `yaml:"font"`
+	Image string `yaml:"image"`
+
+	// Fault is applied to everything sent from this step on.
+	Fault      string `yaml:"fault"`
+	Disconnect bool   `yaml:"disconnect"`
+	Reconnect  bool   `yaml:"reconnect"`
+
+	// Expect checks the display against a golden PNG image or ASCII art,
+	// allowing Tolerance dots to differ.
+	Expect    string `yaml:"expect"`
+	Tolerance int    `yaml:"tolerance"`
+	// ExpectText checks the text read off the display, in Font if one is
+	// given.
+	ExpectText *string `yaml:"expect_text"`
+	// ExpectResponse checks the bytes written back to the sender since
+	// the last check, given as hex.
+	ExpectResponse *string `yaml:"expect_response"`
+}
+
+// action names what a step does, checking it does exactly one thing.
+func (s scenarioStep) action() (string, error) {
+	var actions []string
+	add := func(set bool, name string) {
+		if set {
+			actions = append(actions, name)
+		}
+	}
+	add(s.Packet != "", "packet")
+	add(s.Text != "", "text")
+	add(s.Image != "", "image")
+	add(s.Fault != "", "fault")
+	add(s.Disconnect, "disconnect")
+	add(s.Reconnect, "reconnect")
+	add(s.Expect != "", "expect")
+	add(s.ExpectText != nil, "expect_text")
+	add(s.ExpectResponse != nil, "expect_response")
+	if len(actions) != 1 {
+		return "", fmt.Errorf("each step needs exactly one action, got %d %v", len(actions), actions)
+	}
+	return actions[0], nil
+}
+
+// loadScenario reads a scenario from a YAML or JSON file.
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s scenario
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, fmt.Errorf("error parsing scenario %s: %v", path, err)
+	}
+	if s.Name == "" {
+		s.Name = filepath.Base(path)
+	}
+
+	var last time.Duration
+	for i, step := range s.Steps {
+		if _, err := step.action(); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if step.At == 0 {
+			s.Steps[i].At = last
+		} else if step.At < last {
+			return nil, fmt.Errorf("step %d: at %v is before the previous step", i+1, step.At)
+		}
+		last = s.Steps[i].At
+		if step.Fault != "" && !scenarioFaults[step.Fault] {
+			return nil, fmt.Errorf("step %d: unknown fault %q", i+1, step.Fault)
+		}
+		if step.Tolerance < 0 {
+			return nil, fmt.Errorf("step %d: tolerance must not be negative", i+1)
+		}
+	}
+	return &s, nil
+}
+
+// apply returns cfg with the scenario's configuration applied on top.
+func (s *scenario) apply(cfg Config) (Config, error) {
+	if len(s.Config) == 0 {
+		return cfg, nil
+	}
+	data, err := yaml.Marshal(s.Config)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error in scenario config: %v", err)
+	}
+	return cfg, cfg.validate()
+}
+
+// scenarioRun is the state of a running scenario.
+type scenarioRun struct {
+	dir       string // paths in the scenario are relative to it
+	update    bool   // write golden images instead of checking them
+	out       io.Writer
+	receiver  *receiver
+	fault     string
+	connected bool
+	responses bytes.Buffer
+	checks    int
+	failures  int
+}
+
+// runScenario runs s against the current configuration, reporting each step
+// to out. Paths are relative to dir. With update set, expect steps write
+// their golden image from the display instead of checking it. It returns an
+// error if any check failed.
+func runScenario(s *scenario, dir string, update bool, out io.Writer) error {
+	r := &scenarioRun{dir: dir, update: update, out: out, receiver: newReceiver(), fault: faultNone, connected: true}
+
+	// Packets take the same path as the serial port's, through packetChan
+	// and processPackets.
+	ctx, cancel := context.WithCancel(context.Background())
+	processed := make(chan struct{})
+	go func() {
+		processPackets(ctx)
+		close(processed)
+	}()
+	defer func() {
+		cancel()
+		<-processed
+	}()
+
+	initializeDisplay()
+	resetReassembly()
+	setResponsePort(&r.responses)
+	defer setResponsePort(nil)
+	setSerialConnected(scenarioPort)
+
+	fmt.Fprintf(out, "scenario %s\n", s.Name)
+	start := simClock.Now()
+	for i, step := range s.Steps {
+		sleep(start.Add(step.At).Sub(simClock.Now()))
+		result, err := r.run(step)
+		status := ""
+		if err != nil {
+			r.failures++
+			status = "FAIL: " + err.Error()
+		}
+		fmt.Fprintf(out, "  %3d  %8v  %-40s %s\n", i+1, step.At, result, status)
+	}
+
+	if r.failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", r.failures, r.checks)
+	}
+	fmt.Fprintf(out, "ok: %d checks passed\n", r.checks)
+	return nil
+}
+
+// run does one step, returning a description of it and, for a failed check,
+// why it failed.
+func (r *scenarioRun) run(step scenarioStep) (string, error) {
+	cfg := currentConfig()
+	action, _ := step.action()
+	switch action {
+	case "packet":
+		data, err := parseHex(step.Packet)
+		if err != nil {
+			r.checks++
+			return "packet", err
+		}
+		return r.send(fmt.Sprintf("packet (%d bytes)", len(data)), data), nil
+	case "text":
+		font, err := lookupFont(step.Font)
+		if err != nil {
+			r.checks++
+			return "text", err
+		}
+		frames, err := textEffectFrames(step.Text, font, effectStatic, cfg.Columns, cfg.Rows, 0)
+		if err != nil {
+			r.checks++
+			return "text", err
+		}
+		return r.sendFrame(fmt.Sprintf("text %q", step.Text), frames[0])
+	case "image":
+		frame, err := loadFrameFile(r.path(step.Image), cfg.Rows, cfg.Columns, defaultImageOptions())
+		if err != nil {
+			r.checks++
+			return "image " + step.Image, err
+		}
+		return r.sendFrame("image "+step.Image, frame)
+	case "fault":
+		r.fault = step.Fault
+		return "fault " + step.Fault, nil
+	case "disconnect":
+		r.connected = false
+		resetReassembly()
+		setSerialDisconnected(scenarioPort, errors.New("disconnected by the scenario"))
+		return "disconnect", nil
+	case "reconnect":
+		r.connected = true
+		r.receiver = newReceiver()
+		setSerialConnected(scenarioPort)
+		return "reconnect", nil
+	case "expect":
+		r.checks++
+		return "expect " + step.Expect, r.expect(step.Expect, step.Tolerance)
+	case "expect_text":
+		r.checks++
+		return fmt.Sprintf("expect text %q", *step.ExpectText), r.expectText(*step.ExpectText, step.Font)
+	default:
+		r.checks++
+		return "expect response " + *step.ExpectResponse, r.expectResponse(*step.ExpectResponse)
+	}
+}
+
+func (r *scenarioRun) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(r.dir, name)
+}
+
+// sendFrame sends frame as one Hanover packet per panel.
+func (r *scenarioRun) sendFrame(description string, frame [][]bool) (string, error) {
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		r.checks++
+		return description, err
+	}
+	for _, packet := range packets {
+		description = r.send(description, packet)
+	}
+	return description, nil
+}
+
+// send feeds data through the receiver as if it had been read from the
+// serial port, after applying the current fault, and waits for the packets
+// it completes to be processed.
+func (r *scenarioRun) send(description string, data []byte) string {
+	if !r.connected {
+		return description + ", lost: disconnected"
+	}
+	data = append([]byte(nil), data...)
+	switch r.fault {
+	case faultDrop:
+		return description + ", dropped"
+	case faultCorruptChecksum:
+		if len(data) > 0 {
+			data[len(data)-1] ^= 0x01
+		}
+	case faultTruncate:
+		data = data[:len(data)/2]
+	case faultNoise:
+		data = append([]byte{0xFF, 0x00, 0xA5, 0x5A, 0x80, 0x7F, 0x03, 0xFE}, data...)
+	}
+	if len(data) > 0 {
+		r.receiver.receive(data, func(packet Packet) bool {
+			packetChan <- packet
+			return true
+		})
+		// Steps are checked in order, so let the packets be shown first.
+		waitForPackets()
+	}
+	if r.fault != faultNone {
+		description += ", " + r.fault
+	}
+	return description
+}
+
+func (r *scenarioRun) expect(name string, tolerance int) error {
+	frame := snapshotDisplay()
+	if r.update {
+		return saveGolden(r.path(name), frame)
+	}
+	cfg := currentConfig()
+	want, err := loadGolden(r.path(name), cfg.Rows, cfg.Columns)
+	if err != nil {
+		return err
+	}
+	if result := compareFrames(frame, want, tolerance); !result.Match {
+		b := result.Bounds
+		return fmt.Errorf("%d dots differ (%d extra, %d missing) in the %dx%d area at column %d, row %d",
+			result.Differing, result.Extra, result.Missing, b.Width, b.Height, b.X, b.Y)
+	}
+	return nil
+}
+
+func (r *scenarioRun) expectText(expected, font string) error {
+	result, err := recognizeText(snapshotDisplay(), font)
+	if err != nil {
+		return err
+	}
+	if result.Text != expected {
+		return fmt.Errorf("the display says %q", result.Text)
+	}
+	return nil
+}
+
+func (r *scenarioRun) expectResponse(expected string) error {
+	want, err := parseHex(expected)
+	if err != nil {
+		return err
+	}
+	// Delayed responses are written in the background.
+	waitForResponses()
+	got := append([]byte(nil), r.responses.Bytes()...)
+	r.responses.Reset()
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("got % X", got)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScenario(t *testing.T) {
+	withProtocol(t, Config{Columns: 8, Rows: 16, Address: 1, Response: ResponseConfig{Enabled: true, ACK: "06", NAK: "15"}})
+	dir := t.TempDir()
+
+	frame := newFrame(16, 8)
+	frame[3][5] = true
+	packet := fmt.Sprintf("%X", hanoverPacket(t, 1, frame))
+	path := filepath.Join(dir, "test.yaml")
+	script := `
+steps:
+  - packet: "` + packet + `"
+  - expect: dot.png
+  - expect_response: "06"
+  - fault: truncate
+  - packet: "` + fmt.Sprintf("%X", hanoverPacket(t, 1, newFrame(16, 8))) + `"
+  - expect: dot.png
+  - expect_response: ""
+  - fault: none
+  - disconnect: true
+  - reconnect: true
+  - text: "7"
+  - expect_text: "7"
+`
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadScenario(path)
+	if err != nil {
+		t.Fatalf("Failed to load the scenario: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runScenario(s, dir, true, &out); err != nil {
+		t.Fatalf("Failed to write the golden image: %v\n%s", err, out.String())
+	}
+	golden, err := loadGolden(filepath.Join(dir, "dot.png"), 16, 8)
+	if err != nil {
+		t.Fatalf("Failed to read the golden image: %v", err)
+	}
+	if diff := frameDiff(golden, frame); len(diff) != 0 {
+		t.Errorf("Golden image differs from the frame at %v", diff)
+	}
+
+	out.Reset()
+	if err := runScenario(s, dir, false, &out); err != nil {
+		t.Errorf("Expected the scenario to pass, got %v\n%s", err, out.String())
+	}
+
+	// A different display fails the check and says where.
+	if err := saveGolden(filepath.Join(dir, "dot.png"), newFrame(16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = runScenario(s, dir, false, &out)
+	if err == nil || !strings.Contains(out.String(), "1 dots differ (1 extra, 0 missing) in the 1x1 area at column 5, row 3") {
+		t.Errorf("Expected the scenario to fail on the changed dot, got %v\n%s", err, out.String())
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, script, want string
+	}{
+		{"no action", "steps:\n  - at: 1s\n", "exactly one action"},
+		{"two actions", "steps:\n  - text: A\n    disconnect: true\n", "exactly one action"},
+		{"out of order", "steps:\n  - at: 2s\n    text: A\n  - at: 1s\n    text: B\n", "before the previous step"},
+		{"unknown fault", "steps:\n  - fault: gremlins\n", "unknown fault"},
+		{"unknown field", "steps:\n  - txet: A\n", "error parsing scenario"},
+	} {
+		path := filepath.Join(dir, "scenario.yaml")
+		if err := os.WriteFile(path, []byte(tc.script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadScenario(path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
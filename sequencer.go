@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Text effects supported by the sequencer.
+const (
+	effectStatic     = "static"
+	effectScrollLeft = "scroll-left"
+	effectScrollUp   = "scroll-up"
+	effectBlink      = "blink"
+	effectWipe       = "wipe"
+	effectTypewriter = "typewriter"
+)
+
+const defaultFPS = 10
+
+// Playlist is a sequence of text effects and images played in order.
+type Playlist struct {
+	FPS   float64        `yaml:"fps"`
+	Loop  bool           `yaml:"loop"`
+	Items []PlaylistItem `yaml:"items"`
+}
+
+// PlaylistItem is one entry of a playlist. Exactly one of Text or Image must
+// be set. Animated GIFs play each of their frames in turn, for the frame's
+// own delay when it has one and at the frame rate otherwise. Duration sets how
+// long a blink effect runs; Hold keeps the item's last frame up before moving
+// on to the next item.
+type PlaylistItem struct {
+	Text     string        `yaml:"text"`
+	Font     string        `yaml:"font"`
+	Effect   string        `yaml:"effect"`
+	Image    string        `yaml:"image"`
+	Dither   string        `yaml:"dither"`
+	Invert   bool          `yaml:"invert"`
+	FPS      float64       `yaml:"fps"`
+	Duration time.Duration `yaml:"duration"`
+	Hold     time.Duration `yaml:"hold"`
+}
+
+// frameSink receives the frames produced by the sequencer.
+type frameSink interface {
+	showFrame(frame [][]bool) error
+}
+
+// displaySink shows frames on the simulator's own display.
+type displaySink struct{}
+
+func (displaySink) showFrame(frame [][]bool) error {
+	applyFrame(frame)
+	notifyNewPacket(simClock.Now())
+	return nil
+}
+
+// packetSink writes frames as Hanover packets, one per panel, typically to a
+// serial port connected to a real sign.
+type packetSink struct {
+	w io.Writer
+}
+
+func (s packetSink) showFrame(frame [][]bool) error {
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		return err
+	}
+	for _, packet := range packets {
+		if _, err := s.w.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPlaylist reads a playlist from a YAML file. Relative image paths are
+// resolved against the playlist's directory.
+func loadPlaylist(filename string) (*Playlist, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist: %v", err)
+	}
+
+	var playlist Playlist
+	if err := yaml.Unmarshal(data, &playlist); err != nil {
+		return nil, fmt.Errorf("error parsing playlist: %v", err)
+	}
+	if len(playlist.Items) == 0 {
+		return nil, fmt.Errorf("playlist %s has no items", filename)
+	}
+	for i, item := range playlist.Items {
+		if (item.Text == "") == (item.Image == "") {
+			return nil, fmt.Errorf("playlist item %d: exactly one of text or image must be set", i)
+		}
+		if item.Image != "" && !filepath.IsAbs(item.Image) {
+			playlist.Items[i].Image = filepath.Join(filepath.Dir(filename), item.Image)
+		}
+	}
+	return &playlist, nil
+}
+
+// runSequencer plays playlist on sink until it finishes or ctx is cancelled.
+// Looping playlists only end when ctx is cancelled.
+func runSequencer(ctx context.Context, playlist *Playlist, sink frameSink, columns, rows int) error {
+	for {
+		for i, item := range playlist.Items {
+			fps := item.FPS
+			if fps <= 0 {
+				fps = playlist.FPS
+			}
+			if fps <= 0 {
+				fps = defaultFPS
+			}
+
+			frames, delays, err := itemFrames(item, fps, columns, rows)
+			if err != nil {
+				return fmt.Errorf("playlist item %d: %v", i, err)
+			}
+			interval := time.Duration(float64(time.Second) / fps)
+
+			log.Debugf("Playing playlist item %d: %d frames at %.1f fps", i, len(frames), fps)
+			for j, frame := range frames {
+				if err := sink.showFrame(frame); err != nil {
+					return fmt.Errorf("error showing frame: %v", err)
+				}
+				delay := interval
+				if j < len(delays) && delays[j] > 0 {
+					delay = delays[j]
+				}
+				if err := sleepContext(ctx, delay); err != nil {
+					return err
+				}
+			}
+			if err := sleepContext(ctx, item.Hold); err != nil {
+				return err
+			}
+		}
+		if !playlist.Loop {
+			return nil
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error if it is
+// cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-simClock.After(d):
+		return nil
+	}
+}
+
+// itemFrames renders a playlist item into the frames it plays, along with
+// how long to show each frame. A missing or zero delay plays the frame at
+// the item's frame rate.
+func itemFrames(item PlaylistItem, fps float64, columns, rows int) ([][][]bool, []time.Duration, error) {
+	if item.Image != "" {
+		return imageFrames(item, columns, rows)
+	}
+
+	font, err := lookupFont(item.Font)
+	if err != nil {
+		return nil, nil, err
+	}
+	frames, err := textEffectFrames(item.Text, font, item.Effect, columns, rows, int(item.Duration.Seconds()*fps))
+	return frames, nil, err
+}
+
+// textEffectFrames renders text with the named effect. count is the number
+// of frames a blink runs for; every other effect has a natural length.
+func textEffectFrames(text string, font *bitmapFont, effect string, columns, rows, count int) ([][][]bool, error) {
+	rendered := font.renderText(text)
+	textWidth, textHeight := font.textWidth(text), font.glyphHeight()
+	// Position that centres the text on the display.
+	cx, cy := (columns-textWidth)/2, (rows-textHeight)/2
+	if cx < 0 {
+		cx = 0
+	}
+	if count < 2 {
+		count = 2
+	}
+
+	var frames [][][]bool
+	switch effect {
+	case "", effectStatic:
+		frame := newFrame(rows, columns)
+		blitFrame(frame, rendered, cx, cy)
+		frames = append(frames, frame)
+	case effectScrollLeft:
+		for x := columns; x >= -textWidth; x-- {
+			frame := newFrame(rows, columns)
+			blitFrame(frame, rendered, x, cy)
+			frames = append(frames, frame)
+		}
+	case effectScrollUp:
+		for y := rows; y >= -textHeight; y-- {
+			frame := newFrame(rows, columns)
+			blitFrame(frame, rendered, cx, y)
+			frames = append(frames, frame)
+		}
+	case effectBlink:
+		on := newFrame(rows, columns)
+		blitFrame(on, rendered, cx, cy)
+		off := newFrame(rows, columns)
+		for i := 0; i < count; i++ {
+			if i%2 == 0 {
+				frames = append(frames, on)
+			} else {
+				frames = append(frames, off)
+			}
+		}
+	case effectWipe:
+		full := newFrame(rows, columns)
+		blitFrame(full, rendered, cx, cy)
+		for reveal := 0; reveal <= columns; reveal++ {
+			frame := newFrame(rows, columns)
+			for row := range frame {
+				copy(frame[row][:reveal], full[row][:reveal])
+			}
+			frames = append(frames, frame)
+		}
+	case effectTypewriter:
+		runes := []rune(text)
+		for n := 0; n <= len(runes); n++ {
+			frame := newFrame(rows, columns)
+			blitFrame(frame, font.renderText(string(runes[:n])), cx, cy)
+			frames = append(frames, frame)
+		}
+	default:
+		return nil, fmt.Errorf("unknown effect %q", effect)
+	}
+	return frames, nil
+}
+
+// imageFrames converts an image file into frames; animated GIFs yield one
+// frame per GIF frame, with the delay the GIF gives it.
+func imageFrames(item PlaylistItem, columns, rows int) ([][][]bool, []time.Duration, error) {
+	opts := defaultImageOptions()
+	opts.Dither, opts.Invert = item.Dither, item.Invert
+
+	f, err := os.Open(item.Image)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var images []image.Image
+	var delays []time.Duration
+	if strings.EqualFold(filepath.Ext(item.Image), ".gif") {
+		anim, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding image: %v", err)
+		}
+		// GIF frames may only cover part of the canvas, so composite
+		// them in order.
+		canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+		for i, frame := range anim.Image {
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			snapshot := image.NewRGBA(canvas.Bounds())
+			copy(snapshot.Pix, canvas.Pix)
+			images = append(images, snapshot)
+			// GIF delays are in hundredths of a second.
+			delays = append(delays, time.Duration(anim.Delay[i])*10*time.Millisecond)
+		}
+	} else {
+		img, err := decodeImage(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, img)
+	}
+
+	var frames [][][]bool
+	for _, img := range images {
+		frame, err := imageToFrame(img, columns, rows, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, delays, nil
+}
+
+var (
+	playlistParent context.Context
+	playlistCancel context.CancelFunc
+	playlistDone   chan struct{}
+	playlistMutex  sync.Mutex
+)
+
+// startPlaylist plays the configured playlist on the simulator's display
+// until ctx is cancelled. Reloads switch playlists with switchPlaylist.
+func startPlaylist(ctx context.Context, filename string) {
+	playlistMutex.Lock()
+	playlistParent = ctx
+	playlistMutex.Unlock()
+	switchPlaylist(filename)
+}
+
+// switchPlaylist stops the playlist that is playing, if any, and starts
+// playing filename instead unless it is empty. It does nothing unless
+// startPlaylist has been called.
+func switchPlaylist(filename string) {
+	playlistMutex.Lock()
+	defer playlistMutex.Unlock()
+	if playlistParent == nil {
+		return
+	}
+	if playlistCancel != nil {
+		playlistCancel()
+		<-playlistDone
+		playlistCancel, playlistDone = nil, nil
+	}
+	if filename == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(playlistParent)
+	done := make(chan struct{})
+	playlistCancel, playlistDone = cancel, done
+	go func() {
+		defer close(done)
+		playPlaylist(ctx, filename)
+	}()
+}
+
+// playPlaylist runs the configured playlist on the simulator's display.
+func playPlaylist(ctx context.Context, filename string) {
+	playlist, err := loadPlaylist(filename)
+	if err != nil {
+		log.Errorf("Error loading playlist: %v", err)
+		return
+	}
+	log.Infof("Playing playlist %s", filename)
+	cfg := currentConfig()
+	if err := runSequencer(ctx, playlist, displaySink{}, cfg.Columns, cfg.Rows); err != nil && err != context.Canceled {
+		log.Errorf("Playlist stopped: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	frames [][][]bool
+}
+
+func (s *recordingSink) showFrame(frame [][]bool) error {
+	s.frames = append(s.frames, frame)
+	return nil
+}
+
+func TestRenderText(t *testing.T) {
+	font, err := lookupFont("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := font.renderText("HI")
+	if len(frame) != 7 || len(frame[0]) != 11 {
+		t.Fatalf("Expected a 11x7 frame, got %dx%d", len(frame[0]), len(frame))
+	}
+	// The left stroke of the H and the stem of the I are solid.
+	for row := 0; row < 7; row++ {
+		if !frame[row][0] || !frame[row][8] {
+			t.Errorf("Expected solid strokes in row %d, got %v", row, frame[row])
+		}
+	}
+
+	big, err := lookupFont("10x14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame := big.renderText("HI"); len(frame) != 14 || len(frame[0]) != 22 {
+		t.Errorf("Expected a 22x14 frame, got %dx%d", len(frame[0]), len(frame))
+	}
+}
+
+func TestTextEffectFrames(t *testing.T) {
+	font, _ := lookupFont("")
+	testCases := []struct {
+		effect string
+		frames int
+	}{
+		{effectStatic, 1},
+		{effectScrollLeft, 96 + 11 + 1},
+		{effectScrollUp, 16 + 7 + 1},
+		{effectBlink, 6},
+		{effectWipe, 96 + 1},
+		{effectTypewriter, 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.effect, func(t *testing.T) {
+			frames, err := textEffectFrames("HI", font, tc.effect, 96, 16, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != tc.frames {
+				t.Errorf("Expected %d frames, got %d", tc.frames, len(frames))
+			}
+			for _, frame := range frames {
+				if len(frame) != 16 || len(frame[0]) != 96 {
+					t.Fatalf("Frame has the wrong size: %dx%d", len(frame[0]), len(frame))
+				}
+			}
+		})
+	}
+
+	if _, err := textEffectFrames("HI", font, "spin", 96, 16, 0); err == nil {
+		t.Error("Expected an error for an unknown effect")
+	}
+}
+
+func TestRunSequencer(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "playlist.yaml")
+	err := os.WriteFile(filename, []byte(`
+fps: 1000
+items:
+  - text: "HI"
+    effect: typewriter
+  - text: "OK"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playlist, err := loadPlaylist(filename)
+	if err != nil {
+		t.Fatalf("loadPlaylist failed: %v", err)
+	}
+
+	sink := &recordingSink{}
+	if err := runSequencer(context.Background(), playlist, sink, 96, 16); err != nil {
+		t.Fatalf("runSequencer failed: %v", err)
+	}
+	if len(sink.frames) != 4 {
+		t.Errorf("Expected 4 frames, got %d", len(sink.frames))
+	}
+
+	// Looping playlists run until cancelled.
+	clock := useVirtualClock(t)
+	playlist.Loop = true
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	looping := &recordingSink{}
+	go func() { stopped <- runSequencer(ctx, playlist, looping, 96, 16) }()
+	for i := 0; i < 10; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+	}
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-stopped; err != context.Canceled {
+		t.Errorf("Expected the looping playlist to stop on cancellation, got %v", err)
+	}
+	if len(looping.frames) <= len(sink.frames) {
+		t.Errorf("Expected the playlist to loop, got %d frames", len(looping.frames))
+	}
+}
+
+func TestImageFramesGIFDelays(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{Delay: []int{25, 0}}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		frame.SetColorIndex(i, 0, 1)
+		anim.Image = append(anim.Image, frame)
+	}
+	filename := filepath.Join(t.TempDir(), "anim.gif")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	frames, delays, err := imageFrames(PlaylistItem{Image: filename}, 8, 8)
+	if err != nil {
+		t.Fatalf("imageFrames failed: %v", err)
+	}
+	if len(frames) != 2 || !frames[1][0][1] {
+		t.Fatalf("Expected 2 composited frames, got %d", len(frames))
+	}
+	if len(delays) != 2 || delays[0] != 250*time.Millisecond || delays[1] != 0 {
+		t.Errorf("Expected delays [250ms 0s], got %v", delays)
+	}
+}
@@ -1,46 +1,453 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/tarm/serial"
 )
 
-func readSerialPort() {
-	serialConfig := &serial.Config{
-		Name: config.SerialPort,
-		Baud: config.BaudRate,
+// SerialLineConfig holds the line settings used for every serial port the
+// simulator opens. FlowControl is none, rtscts for hardware handshaking or
+// xonxoff for software flow control.
+type SerialLineConfig struct {
+	DataBits    int    `yaml:"data_bits"`
+	StopBits    int    `yaml:"stop_bits"`
+	Parity      string `yaml:"parity"`
+	FlowControl string `yaml:"flow_control"`
+	// ReadTimeout bounds how long a read blocks, so readers notice when
+	// they are asked to stop even if no data arrives.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	BufferSize  int           `yaml:"buffer_size"`
+	// FramingCheck warns about a likely framing or baud rate mismatch when
+	// this many bytes arrive without an STX. It must be larger than the
+	// longest packet sent; 0 disables the check.
+	FramingCheck int `yaml:"framing_check"`
+	// PacketTimeout discards a partly received packet when no more data
+	// arrives for this long, so a packet cut short does not swallow the
+	// start of the next one. 0 waits forever.
+	PacketTimeout time.Duration `yaml:"packet_timeout"`
+}
+
+// defaultSerialLine returns the 8N1 settings listed in protocol.md.
+func defaultSerialLine() SerialLineConfig {
+	return SerialLineConfig{
+		DataBits:      8,
+		StopBits:      1,
+		Parity:        "none",
+		FlowControl:   "none",
+		ReadTimeout:   500 * time.Millisecond,
+		BufferSize:    512,
+		PacketTimeout: time.Second,
+	}
+}
+
+var serialParities = map[string]serial.Parity{
+	"none":  serial.ParityNone,
+	"odd":   serial.ParityOdd,
+	"even":  serial.ParityEven,
+	"mark":  serial.ParityMark,
+	"space": serial.ParitySpace,
+}
+
+// portConfig returns the tarm/serial configuration for the named port.
+func (l SerialLineConfig) portConfig(name string, baud int) *serial.Config {
+	return &serial.Config{
+		Name:        name,
+		Baud:        baud,
+		ReadTimeout: l.ReadTimeout,
+		Size:        byte(l.DataBits),
+		Parity:      serialParities[l.Parity],
+		StopBits:    serial.StopBits(l.StopBits),
+	}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (l SerialLineConfig) validate(problems []string) []string {
+	if l.DataBits < 5 || l.DataBits > 8 {
+		problems = append(problems, fmt.Sprintf("serial.data_bits must be between 5 and 8, got %d", l.DataBits))
+	}
+	if l.StopBits != 1 && l.StopBits != 2 {
+		problems = append(problems, fmt.Sprintf("serial.stop_bits must be 1 or 2, got %d", l.StopBits))
+	}
+	if _, ok := serialParities[l.Parity]; !ok {
+		problems = append(problems, fmt.Sprintf("serial.parity must be none, odd, even, mark or space, got %q", l.Parity))
+	}
+	switch l.FlowControl {
+	case "none", "rtscts", "xonxoff":
+	default:
+		problems = append(problems, fmt.Sprintf("serial.flow_control must be none, rtscts or xonxoff, got %q", l.FlowControl))
+	}
+	// Timeouts are applied in tenths of a second, and a port without
+	// one blocks shutdown until data arrives.
+	if l.ReadTimeout < 100*time.Millisecond {
+		problems = append(problems, fmt.Sprintf("serial.read_timeout must be at least 100ms, got %v", l.ReadTimeout))
+	}
+	if l.BufferSize <= 0 {
+		problems = append(problems, fmt.Sprintf("serial.buffer_size must be positive, got %d", l.BufferSize))
+	}
+	if l.FramingCheck < 0 {
+		problems = append(problems, fmt.Sprintf("serial.framing_check must not be negative, got %d", l.FramingCheck))
 	}
-	port, err := serial.OpenPort(serialConfig)
+	if l.PacketTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("serial.packet_timeout must not be negative, got %v", l.PacketTimeout))
+	}
+	return problems
+}
+
+var (
+	serialParent context.Context
+	serialCancel context.CancelFunc
+	serialDone   chan struct{}
+	serialMutex  sync.Mutex
+)
+
+// openSerialPort opens the named port with the given line settings,
+// including its flow control.
+func openSerialPort(name string, baud int, line SerialLineConfig) (*serial.Port, error) {
+	port, err := serial.OpenPort(line.portConfig(name, baud))
 	if err != nil {
-		log.Fatalf("Error opening serial port: %v", err)
+		return nil, err
+	}
+	if err := setFlowControl(name, line.FlowControl); err != nil {
+		port.Close()
+		return nil, fmt.Errorf("error setting flow control: %v", err)
 	}
+	return port, nil
+}
 
-	log.Infof("Started reading from serial port %s", config.SerialPort)
+// Reconnection backoff: the delay between attempts to reopen a missing or
+// failed port doubles from serialMinBackoff up to serialMaxBackoff.
+const (
+	serialMinBackoff = 500 * time.Millisecond
+	serialMaxBackoff = 30 * time.Second
+	serialPollDevice = 250 * time.Millisecond
+)
 
+// SerialStatus describes the state of the serial connection for the web UI.
+// The times are pointers so they are left out of the JSON until set.
+type SerialStatus struct {
+	Port           string     `json:"port"`
+	Connected      bool       `json:"connected"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+	Reconnects     int        `json:"reconnects"`
+	// FramingWarning is set while the incoming data looks like it is
+	// being received with the wrong line settings.
+	FramingWarning string `json:"framing_warning,omitempty"`
+}
+
+var (
+	serialStatus      SerialStatus
+	serialStatusMutex sync.Mutex
+)
+
+// getSerialStatus returns a copy of the current serial status.
+func getSerialStatus() SerialStatus {
+	serialStatusMutex.Lock()
+	defer serialStatusMutex.Unlock()
+	return serialStatus
+}
+
+func setSerialConnected(port string) {
+	serialStatusMutex.Lock()
+	defer serialStatusMutex.Unlock()
+	if serialStatus.Port == port && serialStatus.LastErrorAt != nil {
+		serialStatus.Reconnects++
+	}
+	serialStatus.Port = port
+	serialStatus.Connected = true
+	now := simClock.Now()
+	serialStatus.ConnectedSince = &now
+	serialStatus.FramingWarning = ""
+}
+
+func setFramingWarning(warning string) {
+	serialStatusMutex.Lock()
+	defer serialStatusMutex.Unlock()
+	serialStatus.FramingWarning = warning
+}
+
+// framingMonitor watches received bytes for signs of a framing or baud rate
+// mismatch. Hanover packets all start with STX, so a long run of bytes
+// without one means the bytes are not being read as they were sent.
+type framingMonitor struct {
+	limit    int
+	sinceSTX int
+	nonASCII int
+	warned   bool
+}
+
+// observe records data, warning once each time limit bytes pass without an
+// STX and clearing the warning when one arrives.
+func (m *framingMonitor) observe(data []byte) {
+	if m.limit <= 0 {
+		return
+	}
+	for _, b := range data {
+		if b == hanoverSTX {
+			if m.warned {
+				log.Info("Serial framing looks correct again")
+				setFramingWarning("")
+			}
+			m.sinceSTX, m.nonASCII, m.warned = 0, 0, false
+			continue
+		}
+		m.sinceSTX++
+		if b > 0x7F {
+			m.nonASCII++
+		}
+		if m.sinceSTX >= m.limit && !m.warned {
+			m.warned = true
+			warning := fmt.Sprintf("no STX in %d bytes (%d outside ASCII), check baud rate, data bits, parity and stop bits", m.sinceSTX, m.nonASCII)
+			log.Warnf("Possible framing or baud rate mismatch on %s: %s", currentConfig().SerialPort, warning)
+			setFramingWarning("possible framing mismatch: " + warning)
+		}
+	}
+}
+
+func setSerialDisconnected(port string, err error) {
+	serialStatusMutex.Lock()
+	defer serialStatusMutex.Unlock()
+	serialStatus.Port = port
+	serialStatus.Connected = false
+	serialStatus.ConnectedSince = nil
+	if err != nil {
+		now := simClock.Now()
+		serialStatus.LastError = err.Error()
+		serialStatus.LastErrorAt = &now
+	}
+}
+
+// startSerialPort reads from the configured serial port in the background
+// until ctx is cancelled, reconnecting whenever the port fails.
+func startSerialPort(ctx context.Context) {
+	serialMutex.Lock()
+	serialParent = ctx
+	serialMutex.Unlock()
+
+	cfg := currentConfig()
+	startSerialReader(cfg.SerialPort, cfg.BaudRate, cfg.Serial)
+}
+
+// startSerialReader reads from the named port in the background, first
+// stopping whichever port was being read before.
+func startSerialReader(name string, baud int, line SerialLineConfig) {
+	stopSerialReader()
+
+	serialMutex.Lock()
+	defer serialMutex.Unlock()
+	if serialParent == nil {
+		serialParent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(serialParent)
+	done := make(chan struct{})
+	serialCancel, serialDone = cancel, done
+
+	go func() {
+		defer close(done)
+		superviseSerialPort(ctx, name, baud, line)
+	}()
+}
+
+// superviseSerialPort keeps the named port open and read until ctx is
+// cancelled. When the port cannot be opened or a read fails it retries with
+// exponential backoff, retrying immediately if a removed device reappears.
+func superviseSerialPort(ctx context.Context, name string, baud int, line SerialLineConfig) {
+	backoff := serialMinBackoff
 	for {
-		buf := make([]byte, 512)
-		n, err := port.Read(buf)
+		port, err := openSerialPort(name, baud, line)
 		if err != nil {
-			log.Errorf("Error reading from serial port: %v", err)
+			setSerialDisconnected(name, err)
+			log.Warnf("Error opening serial port %s: %v (retrying in %v)", name, err, backoff)
+			if !waitForDevice(ctx, name, backoff) {
+				setSerialDisconnected(name, nil)
+				return
+			}
+			backoff = nextBackoff(backoff)
 			continue
 		}
 
-		if n > 0 {
-			data := buf[:n]
-			log.Infof("Received data: length=%d, first byte=0x%02X, last byte=0x%02X",
-				len(data), data[0], data[len(data)-1])
-
-			completePackets := reassemblePacket(data)
-			for _, completePacket := range completePackets {
-				packet := Packet{
-					Timestamp: time.Now(),
-					Data:      completePacket,
-				}
-				packetChan <- packet
-				log.Infof("Assembled complete packet: length=%d, first byte=0x%02X, last byte=0x%02X",
-					len(completePacket), completePacket[0], completePacket[len(completePacket)-1])
+		backoff = serialMinBackoff
+		// Bytes from before the disconnect cannot be completed by
+		// whatever arrives after it.
+		resetReassembly()
+		setSerialConnected(name)
+		setResponsePort(port)
+
+		err = readFromPort(ctx, port)
+		setResponsePort(nil)
+		port.Close()
+		if ctx.Err() != nil {
+			setSerialDisconnected(name, nil)
+			return
+		}
+		setSerialDisconnected(name, err)
+		log.Errorf("Lost serial port %s: %v", name, err)
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > serialMaxBackoff {
+		backoff = serialMaxBackoff
+	}
+	return backoff
+}
+
+// waitForDevice waits up to d before the next attempt to open the named
+// device, returning early if a device that was missing reappears. It returns
+// false if ctx is cancelled.
+func waitForDevice(ctx context.Context, name string, d time.Duration) bool {
+	_, err := os.Stat(name)
+	missing := err != nil
+
+	timeout := simClock.After(d)
+	ticker := simClock.NewTicker(serialPollDevice)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+			return true
+		case <-ticker.C():
+			if _, err := os.Stat(name); missing && err == nil {
+				log.Infof("Serial device %s reappeared", name)
+				return true
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// stopSerialReader stops the running serial reader, if any, and waits for
+// it to close its port.
+func stopSerialReader() {
+	serialMutex.Lock()
+	cancel, done := serialCancel, serialDone
+	serialMutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+
+	serialMutex.Lock()
+	if serialDone == done {
+		serialCancel, serialDone = nil, nil
+	}
+	serialMutex.Unlock()
+}
+
+// readFromPort feeds data from port into the packet pipeline until ctx is
+// cancelled or reading fails.
+func readFromPort(ctx context.Context, port io.Reader) error {
+	cfg := currentConfig()
+	log.Infof("Started reading from serial port %s", cfg.SerialPort)
+
+	bufferSize := cfg.Serial.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSerialLine().BufferSize
+	}
+	r := newReceiver()
+	deliver := func(packet Packet) bool {
+		select {
+		case packetChan <- packet:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		buf := make([]byte, bufferSize)
+		n, err := port.Read(buf)
+		if ctx.Err() != nil {
+			log.Infof("Stopped reading from serial port %s", cfg.SerialPort)
+			return nil
+		}
+		// A read that times out without data is reported as EOF.
+		if err == io.EOF && n == 0 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading from serial port: %v", err)
+		}
+
+		if n > 0 && !r.receive(buf[:n], deliver) {
+			return nil
+		}
+	}
+}
+
+// receiver turns the data read from a port into packets.
+type receiver struct {
+	monitor  *framingMonitor
+	detector *protocolDetector
+	lastData time.Time
+}
+
+func newReceiver() *receiver {
+	return &receiver{
+		monitor:  &framingMonitor{limit: currentConfig().Serial.FramingCheck},
+		detector: &protocolDetector{},
+	}
+}
+
+// receive handles one read's worth of data, passing each packet it completes
+// to deliver. It returns false as soon as deliver does.
+func (r *receiver) receive(data []byte, deliver func(Packet) bool) bool {
+	metrics.read(len(data))
+	log.Infof("Received data: length=%d, first byte=0x%02X, last byte=0x%02X",
+		len(data), data[0], data[len(data)-1])
+
+	now := simClock.Now()
+	if timeout := currentConfig().Serial.PacketTimeout; timeout > 0 && len(partialPacket) > 0 && now.Sub(r.lastData) >= timeout {
+		log.Warnf("Discarding %d bytes of an incomplete packet after %v without data", len(partialPacket), now.Sub(r.lastData))
+		metrics.packetRejected(rejectIncomplete)
+		resetReassembly()
+	}
+	r.lastData = now
+
+	r.detector.observe(data)
+	// The framing check looks for Hanover's STX.
+	if activeDecoder().name() == protocolHanover {
+		r.monitor.observe(data)
+	}
+	b := currentBridge()
+	if b != nil {
+		b.forwardRaw(data)
+	}
+
+	completePackets := reassemblePacket(data)
+	for _, completePacket := range completePackets {
+		if b != nil {
+			b.forwardPacket(completePacket)
+		}
+		echoPacket(completePacket)
+		packet := Packet{
+			Timestamp: simClock.Now(),
+			Data:      completePacket,
+			FromPort:  true,
+		}
+		if !deliver(packet) {
+			return false
+		}
+		log.Infof("Assembled complete packet: length=%d, first byte=0x%02X, last byte=0x%02X",
+			len(completePacket), completePacket[0], completePacket[len(completePacket)-1])
+	}
+	return true
+}
+
+// serialStarted reports whether a serial reader is running.
+func serialStarted() bool {
+	serialMutex.Lock()
+	defer serialMutex.Unlock()
+	return serialCancel != nil
+}
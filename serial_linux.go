@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setFlowControl turns on the named flow control for the serial device
+// name. tarm/serial always opens ports without flow control and keeps its
+// file descriptor to itself, so the terminal settings, which belong to the
+// device rather than to one descriptor, are changed through a second one.
+func setFlowControl(name, flowControl string) error {
+	if flowControl == "none" {
+		return nil
+	}
+	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	switch flowControl {
+	case "rtscts":
+		t.Cflag |= unix.CRTSCTS
+	case "xonxoff":
+		t.Iflag |= unix.IXON | unix.IXOFF
+		t.Cc[unix.VSTART] = 0x11
+		t.Cc[unix.VSTOP] = 0x13
+	default:
+		return fmt.Errorf("unknown flow control %q", flowControl)
+	}
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
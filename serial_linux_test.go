@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenSerialPortFlowControl(t *testing.T) {
+	for _, tc := range []struct {
+		flowControl string
+		rtscts      bool
+		xonxoff     bool
+	}{
+		{"none", false, false},
+		{"rtscts", true, false},
+		{"xonxoff", false, true},
+	} {
+		name := openPTY(t)
+		line := defaultSerialLine()
+		line.FlowControl = tc.flowControl
+		port, err := openSerialPort(name, 9600, line)
+		if err != nil {
+			t.Fatalf("openSerialPort with %s failed: %v", tc.flowControl, err)
+		}
+
+		fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		unix.Close(fd)
+		port.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtscts := termios.Cflag&unix.CRTSCTS != 0; rtscts != tc.rtscts {
+			t.Errorf("%s: expected RTS/CTS %v, got %v", tc.flowControl, tc.rtscts, rtscts)
+		}
+		if xonxoff := termios.Iflag&(unix.IXON|unix.IXOFF) == unix.IXON|unix.IXOFF; xonxoff != tc.xonxoff {
+			t.Errorf("%s: expected XON/XOFF %v, got %v", tc.flowControl, tc.xonxoff, xonxoff)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setFlowControl only supports turning flow control off outside Linux.
+func setFlowControl(name, flowControl string) error {
+	if flowControl == "none" {
+		return nil
+	}
+	return fmt.Errorf("flow control %s is only supported on Linux", flowControl)
+}
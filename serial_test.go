@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkReader returns its chunks one read at a time, then fails with err.
+type chunkReader struct {
+	chunks [][]byte
+	err    error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestReadFromPortStopsOnError(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	partialPacket = nil
+	packetChan = make(chan Packet, 10)
+
+	packet := hanoverPacket(t, 1, newFrame(16, 8))
+	port := &chunkReader{
+		chunks: [][]byte{packet[:5], packet[5:]},
+		err:    errors.New("device removed"),
+	}
+
+	err := readFromPort(context.Background(), port)
+	if err == nil {
+		t.Fatal("Expected readFromPort to return the read error")
+	}
+	if len(packetChan) != 1 {
+		t.Fatalf("Expected 1 reassembled packet, got %d", len(packetChan))
+	}
+	if received := <-packetChan; string(received.Data) != string(packet) {
+		t.Errorf("Expected %q, got %q", packet, received.Data)
+	}
+}
+
+func TestProcessPacketsDrainsOnShutdown(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	packetChan = make(chan Packet, 10)
+	packetLog = nil
+
+	for i := 0; i < 3; i++ {
+		packetChan <- Packet{Data: hanoverPacket(t, 1, newFrame(16, 8))}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	processPackets(ctx)
+
+	if len(packetChan) != 0 || len(packetLog) != 3 {
+		t.Errorf("Expected all 3 queued packets to be processed, %d left and %d logged", len(packetChan), len(packetLog))
+	}
+}
+
+func TestWaitForPackets(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	packetChan = make(chan Packet, 10)
+	packetLog = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		processPackets(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	frame := newFrame(16, 8)
+	frame[3][4] = true
+	packetChan <- Packet{Data: hanoverPacket(t, 1, newFrame(16, 8))}
+	packetChan <- Packet{Data: hanoverPacket(t, 1, frame)}
+	waitForPackets()
+
+	// The marker waitForPackets sends is not handled as a packet.
+	if n := len(recentPackets(10)); n != 2 {
+		t.Errorf("Expected 2 packets to be processed, got %d", n)
+	}
+	if !snapshotDisplay()[3][4] {
+		t.Error("Expected the display to show the last packet once processed")
+	}
+}
+
+func TestWaitForDeviceReappears(t *testing.T) {
+	clock := useVirtualClock(t)
+	name := filepath.Join(t.TempDir(), "ttyUSB0")
+	reappeared := make(chan bool)
+	go func() { reappeared <- waitForDevice(context.Background(), name, 10*time.Second) }()
+
+	// Wait for the timeout and the poll ticker, then bring the device back
+	// well before the timeout.
+	clock.BlockUntil(2)
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(serialPollDevice)
+	if !<-reappeared {
+		t.Fatal("Expected waitForDevice to return true")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitForDevice(ctx, name, 10*time.Second) {
+		t.Error("Expected waitForDevice to return false once cancelled")
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := serialMinBackoff
+	for i := 0; i < 20; i++ {
+		backoff = nextBackoff(backoff)
+	}
+	if backoff != serialMaxBackoff {
+		t.Errorf("Expected backoff to be capped at %v, got %v", serialMaxBackoff, backoff)
+	}
+}
+
+func TestSuperviseSerialPortReportsErrors(t *testing.T) {
+	clock := useVirtualClock(t)
+	name := filepath.Join(t.TempDir(), "missing")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		superviseSerialPort(ctx, name, 4800, defaultSerialLine())
+		close(done)
+	}()
+
+	// Let the first retry come due, then stop while waiting for the next.
+	clock.BlockUntil(2)
+	clock.Advance(serialMinBackoff)
+	clock.BlockUntil(2)
+	cancel()
+	<-done
+
+	status := getSerialStatus()
+	if status.Connected || status.Port != name || status.LastError == "" {
+		t.Errorf("Expected a disconnected status with an error, got %+v", status)
+	}
+}
+
+func TestSerialStatusJSON(t *testing.T) {
+	saved := getSerialStatus()
+	t.Cleanup(func() {
+		serialStatusMutex.Lock()
+		serialStatus = saved
+		serialStatusMutex.Unlock()
+	})
+	serialStatusMutex.Lock()
+	serialStatus = SerialStatus{}
+	serialStatusMutex.Unlock()
+
+	status := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var body struct {
+			Serial map[string]interface{} `json:"serial"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid /status response: %v", err)
+		}
+		return body.Serial
+	}
+
+	// Times that were never set are left out rather than sent as year 1.
+	s := status()
+	if _, ok := s["connected_since"]; ok {
+		t.Errorf("Expected no connected_since before connecting, got %v", s)
+	}
+	if _, ok := s["last_error_at"]; ok {
+		t.Errorf("Expected no last_error_at before an error, got %v", s)
+	}
+
+	setSerialConnected("/dev/ttyTEST")
+	if s := status(); s["connected_since"] == nil {
+		t.Errorf("Expected connected_since once connected, got %v", s)
+	}
+	setSerialDisconnected("/dev/ttyTEST", errors.New("unplugged"))
+	if s := status(); s["connected_since"] != nil || s["last_error_at"] == nil {
+		t.Errorf("Expected only last_error_at after a failure, got %v", s)
+	}
+}
+
+func TestSerialLineValidate(t *testing.T) {
+	line := defaultSerialLine()
+	line.DataBits = 9
+	line.Parity = "sometimes"
+	line.FlowControl = "dtrdsr"
+
+	problems := line.validate(nil)
+	if len(problems) != 3 {
+		t.Errorf("Expected 3 problems, got %q", problems)
+	}
+	if !strings.Contains(strings.Join(problems, "\n"), "flow_control must be none, rtscts or xonxoff") {
+		t.Errorf("Expected unknown flow control to be reported, got %q", problems)
+	}
+	for _, flowControl := range []string{"rtscts", "xonxoff"} {
+		line := defaultSerialLine()
+		line.FlowControl = flowControl
+		if problems := line.validate(nil); len(problems) != 0 {
+			t.Errorf("Expected flow_control %s to be valid, got %q", flowControl, problems)
+		}
+	}
+	if problems := defaultSerialLine().validate(nil); len(problems) != 0 {
+		t.Errorf("Expected the default line settings to be valid, got %q", problems)
+	}
+}
+
+func TestFramingMonitor(t *testing.T) {
+	setFramingWarning("")
+	monitor := &framingMonitor{limit: 64}
+	garbage := bytes.Repeat([]byte{0xFF, 0x80, 0x7E, 0xFE}, 8)
+
+	monitor.observe(garbage)
+	if getSerialStatus().FramingWarning != "" {
+		t.Error("Expected no warning before the limit is reached")
+	}
+	monitor.observe(garbage)
+	if getSerialStatus().FramingWarning == "" {
+		t.Error("Expected a warning after 64 bytes without an STX")
+	}
+
+	monitor.observe(hanoverPacket(t, 1, newFrame(16, 8)))
+	if warning := getSerialStatus().FramingWarning; warning != "" {
+		t.Errorf("Expected the warning to clear on an STX, got %q", warning)
+	}
+}
@@ -4,22 +4,32 @@ import (
 	"time"
 )
 
+// testSimulator sends a test pattern, the top and bottom rows of the sign,
+// through the packet pipeline and logs the display once it has had a second
+// to process it.
 func testSimulator() {
 	log.Info("Running test simulation")
-	testPacket := []byte{0x02, 0x11, 0x01, 0x00, 0xC0}
-	for i := 0; i < 192; i++ {
-		testPacket = append(testPacket, 0xAA)
+	cfg := currentConfig()
+	frame := newFrame(cfg.Rows, cfg.Columns)
+	for col := 0; col < cfg.Columns; col++ {
+		frame[0][col] = true
+		frame[cfg.Rows-1][col] = true
 	}
-	testPacket = append(testPacket, 0x03)
 
-	packet := Packet{
-		Timestamp: time.Now(),
-		Data:      testPacket,
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		log.Errorf("Error encoding the test pattern: %v", err)
+		return
+	}
+	for _, data := range packets {
+		packetChan <- Packet{
+			Timestamp: simClock.Now(),
+			Data:      data,
+		}
+		log.Infof("Sent test packet: length=%d", len(data))
 	}
-	packetChan <- packet
-	log.Infof("Sent test packet: length=%d", len(testPacket))
 
-	time.Sleep(time.Second) // Give some time for processing
+	sleep(time.Second) // Give some time for processing
 
 	display.mu.Lock()
 	defer display.mu.Unlock()
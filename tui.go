@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// User interfaces serve can run.
+const (
+	uiWeb  = "web"
+	uiTUI  = "tui"
+	uiBoth = "both"
+	uiNone = "none" // headless: only the serial port, parser, logs and metrics
+)
+
+// Ways the terminal UI draws the display.
+const (
+	tuiModeBlocks  = "blocks"  // half blocks, one column by two rows per cell
+	tuiModeBraille = "braille" // braille, two columns by four rows per cell
+	tuiModeDots    = "dots"    // one character per dot
+)
+
+var tuiModes = []string{tuiModeBlocks, tuiModeBraille, tuiModeDots}
+
+const (
+	ansiAmber = "\x1b[38;5;214m"
+	ansiDim   = "\x1b[38;5;238m"
+	ansiRed   = "\x1b[31m"
+	ansiBold  = "\x1b[1m"
+	ansiReset = "\x1b[0m"
+)
+
+// tuiRefresh is how often the terminal UI redraws.
+const tuiRefresh = 100 * time.Millisecond
+
+// renderFrame draws frame as plain text lines in the given mode.
+func renderFrame(frame [][]bool, mode string) []string {
+	rows := len(frame)
+	columns := 0
+	if rows > 0 {
+		columns = len(frame[0])
+	}
+	at := func(row, col int) bool {
+		return row < rows && col < columns && frame[row][col]
+	}
+
+	var lines []string
+	switch mode {
+	case tuiModeBraille:
+		// Braille dot numbering: bits 0-2 and 6 are the left column
+		// from the top, bits 3-5 and 7 the right column.
+		offsets := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+		for row := 0; row < rows; row += 4 {
+			var b strings.Builder
+			for col := 0; col < columns; col += 2 {
+				r := rune(0x2800)
+				for dy := 0; dy < 4; dy++ {
+					for dx := 0; dx < 2; dx++ {
+						if at(row+dy, col+dx) {
+							r |= offsets[dy][dx]
+						}
+					}
+				}
+				b.WriteRune(r)
+			}
+			lines = append(lines, b.String())
+		}
+	case tuiModeDots:
+		for row := 0; row < rows; row++ {
+			var b strings.Builder
+			for col := 0; col < columns; col++ {
+				if at(row, col) {
+					b.WriteRune('●')
+				} else {
+					b.WriteRune('·')
+				}
+			}
+			lines = append(lines, b.String())
+		}
+	default:
+		for row := 0; row < rows; row += 2 {
+			var b strings.Builder
+			for col := 0; col < columns; col++ {
+				top, bottom := at(row, col), at(row+1, col)
+				switch {
+				case top && bottom:
+					b.WriteRune('█')
+				case top:
+					b.WriteRune('▀')
+				case bottom:
+					b.WriteRune('▄')
+				default:
+					b.WriteRune(' ')
+				}
+			}
+			lines = append(lines, b.String())
+		}
+	}
+	return lines
+}
+
+// colorizeFrameLine colours a line from renderFrame: lit dots amber and, in
+// dots mode, unlit dots dim.
+func colorizeFrameLine(line, mode string) string {
+	if mode != tuiModeDots {
+		return ansiAmber + line + ansiReset
+	}
+	line = strings.ReplaceAll(line, "●", ansiAmber+"●")
+	line = strings.ReplaceAll(line, "·", ansiDim+"·")
+	return line + ansiReset
+}
+
+// truncateRunes cuts s to at most width runes.
+func truncateRunes(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return s
+}
+
+// tuiLogHook keeps the most recent warnings and errors for the terminal UI.
+type tuiLogHook struct {
+	mu      sync.Mutex
+	entries []string
+	limit   int
+}
+
+func (h *tuiLogHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (h *tuiLogHook) Fire(entry *logrus.Entry) error {
+	line := fmt.Sprintf("%s %-5s %s", entry.Time.Format("15:04:05"), strings.ToUpper(entry.Level.String()), entry.Message)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.limit {
+		h.entries = h.entries[len(h.entries)-h.limit:]
+	}
+	return nil
+}
+
+// recent returns the last n entries, oldest first.
+func (h *tuiLogHook) recent(n int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) > n {
+		return append([]string(nil), h.entries[len(h.entries)-n:]...)
+	}
+	return append([]string(nil), h.entries...)
+}
+
+// terminalUI draws the display, recent packets and errors on a terminal.
+type terminalUI struct {
+	out  io.Writer
+	hook *tuiLogHook
+
+	// mu guards the settings changed by key presses.
+	mu    sync.Mutex
+	mode  int
+	color bool
+}
+
+// quietForTUI stops log and gin output from scribbling over the terminal
+// UI; warnings and errors still reach the UI through its log hook. It must
+// run before the web server's router is created.
+func quietForTUI() *tuiLogHook {
+	hook := &tuiLogHook{limit: 50}
+	log.AddHook(hook)
+	log.SetOutput(ioutil.Discard)
+	gin.DefaultWriter = ioutil.Discard
+	gin.DefaultErrorWriter = ioutil.Discard
+	return hook
+}
+
+// runTUI shows the terminal UI until ctx is cancelled or the user quits,
+// in which case quit is called.
+func runTUI(ctx context.Context, hook *tuiLogHook, quit func()) {
+	ui := &terminalUI{out: os.Stdout, color: true, hook: hook}
+
+	restore, err := enableRawMode(int(os.Stdin.Fd()))
+	if err != nil {
+		log.Warnf("Keyboard input unavailable in the terminal UI: %v", err)
+	} else {
+		defer restore()
+		go ui.readKeys(os.Stdin, quit)
+	}
+
+	// Alternate screen, cursor hidden. Once the terminal is restored the
+	// log can write to it again, so shutdown messages are still seen.
+	fmt.Fprint(ui.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(ui.out, "\x1b[?25h\x1b[?1049l")
+		log.SetOutput(os.Stderr)
+	}()
+
+	ticker := simClock.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		ui.draw()
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readKeys handles key presses: q or Ctrl+C quits, m cycles the render
+// mode and c toggles colour.
+func (ui *terminalUI) readKeys(r io.Reader, quit func()) {
+	br := bufio.NewReader(r)
+	for {
+		key, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		ui.mu.Lock()
+		switch key {
+		case 'm', 'M':
+			ui.mode = (ui.mode + 1) % len(tuiModes)
+		case 'c', 'C':
+			ui.color = !ui.color
+		}
+		ui.mu.Unlock()
+		if key == 'q' || key == 'Q' || key == 3 {
+			quit()
+			return
+		}
+	}
+}
+
+// draw renders one screen. Lines end in \r\n since raw mode turns off
+// output post-processing.
+func (ui *terminalUI) draw() {
+	width, height := terminalSize(int(os.Stdout.Fd()))
+	lines := ui.screen(width, height)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\x1b[K\r\n")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(ui.out, b.String())
+}
+
+// screen lays out the UI for a terminal of the given size.
+func (ui *terminalUI) screen(width, height int) []string {
+	ui.mu.Lock()
+	mode, color := tuiModes[ui.mode], ui.color
+	ui.mu.Unlock()
+
+	style := func(code, s string) string {
+		if color {
+			return code + s + ansiReset
+		}
+		return s
+	}
+
+	cfg := currentConfig()
+	serial := getSerialStatus()
+	state := "disconnected"
+	if serial.Connected {
+		state = "connected"
+	}
+	if serial.Port == "" {
+		serial.Port = cfg.SerialPort
+	}
+	var lines []string
+	lines = append(lines, style(ansiBold, truncateRunes(fmt.Sprintf("Hanover Display Simulator  %dx%d  address %d  %s %s",
+		cfg.Columns, cfg.Rows, cfg.Address, serial.Port, state), width)))
+	lines = append(lines, truncateRunes(fmt.Sprintf("[m] mode: %s  [c] colour  [q] quit", mode), width))
+	lines = append(lines, "")
+
+	for _, line := range renderFrame(snapshotDisplay(), mode) {
+		line = truncateRunes(line, width)
+		if color {
+			line = colorizeFrameLine(line, mode)
+		}
+		lines = append(lines, line)
+	}
+
+	// Share what is left between packets and errors.
+	remaining := height - len(lines) - 4
+	if remaining < 2 {
+		return lines
+	}
+	packetLines := remaining / 2
+	errorLines := remaining - packetLines
+
+	lines = append(lines, "", style(ansiBold, "Recent packets"))
+	packets := recentPackets(packetLines)
+	for i := len(packets) - 1; i >= 0; i-- {
+		lines = append(lines, truncateRunes(describePacket(packets[i]), width))
+	}
+	for i := len(packets); i < packetLines; i++ {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "", style(ansiBold, "Errors"))
+	for _, entry := range ui.hook.recent(errorLines) {
+		lines = append(lines, style(ansiRed, truncateRunes(entry, width)))
+	}
+	return lines
+}
+
+// describePacket summarises a packet on one line.
+func describePacket(packet Packet) string {
+	return fmt.Sprintf("%s  %4d bytes  %s", packet.Timestamp.Format("15:04:05.000"), len(packet.Data), activeDecoder().describe(packet.Data))
+}
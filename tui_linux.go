@@ -0,0 +1,31 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// enableRawMode switches the terminal on fd to unbuffered input without
+// echo, so single key presses reach the terminal UI. It returns a function
+// restoring the previous settings.
+func enableRawMode(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
+
+// terminalSize returns the size of the terminal on fd, or 80x24 if it is
+// not a terminal.
+func terminalSize(fd int) (width, height int) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// enableRawMode is only implemented on Linux; elsewhere the terminal UI runs
+// without keyboard input.
+func enableRawMode(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// terminalSize assumes a standard 80x24 terminal.
+func terminalSize(fd int) (width, height int) {
+	return 80, 24
+}
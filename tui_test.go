@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRenderFrame(t *testing.T) {
+	frame := newFrame(4, 4)
+	frame[0][0] = true
+	frame[1][0] = true
+	frame[0][1] = true
+	frame[3][3] = true
+
+	blocks := renderFrame(frame, tuiModeBlocks)
+	if want := []string{"█▀  ", "   ▄"}; strings.Join(blocks, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected blocks rendering %q", blocks)
+	}
+
+	braille := renderFrame(frame, tuiModeBraille)
+	// Dots 1, 2 and 4 on the left cell; dot 8 on the right one.
+	if want := []string{"⠋⢀"}; strings.Join(braille, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected braille rendering %q", braille)
+	}
+
+	dots := renderFrame(frame, tuiModeDots)
+	if len(dots) != 4 || dots[0] != "●●··" || dots[3] != "···●" {
+		t.Errorf("Unexpected dots rendering %q", dots)
+	}
+}
+
+func TestTUILogHook(t *testing.T) {
+	hook := &tuiLogHook{limit: 2}
+	for _, msg := range []string{"first", "second", "third"} {
+		hook.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.ErrorLevel, Message: msg})
+	}
+	recent := hook.recent(5)
+	if len(recent) != 2 || !strings.HasSuffix(recent[0], "ERROR second") || !strings.HasSuffix(recent[1], "ERROR third") {
+		t.Errorf("Expected the last 2 entries, got %q", recent)
+	}
+}
+
+func TestDescribePacket(t *testing.T) {
+	packet := Packet{Timestamp: time.Now(), Data: hanoverPacket(t, 3, newFrame(16, 8))}
+	if got := describePacket(packet); !strings.Contains(got, "address 3") || !strings.HasSuffix(got, "checksum ok") {
+		t.Errorf("Unexpected packet description %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WearConfig sets where the per-dot flip counters are saved so they survive
+// restarts. An empty File keeps them in memory only.
+type WearConfig struct {
+	File         string        `yaml:"file"`
+	SaveInterval time.Duration `yaml:"save_interval"`
+}
+
+func defaultWear() WearConfig {
+	return WearConfig{
+		File:         "dot_wear.json",
+		SaveInterval: time.Minute,
+	}
+}
+
+// validate appends a problem to problems for each unusable setting.
+func (c WearConfig) validate(problems []string) []string {
+	if c.File != "" && c.SaveInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("wear.save_interval must be positive, got %v", c.SaveInterval))
+	}
+	return problems
+}
+
+// wearReport is the saved and exported form of the flip counters, indexed
+// [row][column].
+type wearReport struct {
+	Rows    int        `json:"rows"`
+	Columns int        `json:"columns"`
+	Total   uint64     `json:"total"`
+	Max     uint64     `json:"max"`
+	Flips   [][]uint64 `json:"flips"`
+}
+
+// snapshotWear returns a copy of the flip counters sized to the display.
+func snapshotWear() wearReport {
+	display.mu.Lock()
+	defer display.mu.Unlock()
+
+	report := wearReport{Rows: len(display.pixels)}
+	if report.Rows > 0 {
+		report.Columns = len(display.pixels[0])
+	}
+	report.Flips = make([][]uint64, report.Rows)
+	for row := range report.Flips {
+		report.Flips[row] = make([]uint64, report.Columns)
+		if row < len(display.flips) {
+			copy(report.Flips[row], display.flips[row])
+		}
+		for _, flips := range report.Flips[row] {
+			report.Total += flips
+			if flips > report.Max {
+				report.Max = flips
+			}
+		}
+	}
+	return report
+}
+
+// loadWear restores the flip counters saved in filename, if it exists.
+func loadWear(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading dot wear: %v", err)
+	}
+
+	var report wearReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("error parsing dot wear: %v", err)
+	}
+
+	display.mu.Lock()
+	display.flips = report.Flips
+	display.mu.Unlock()
+	log.Infof("Loaded dot wear from %s: %d flips, at most %d on one dot", filename, report.Total, report.Max)
+	return nil
+}
+
+// saveWear writes the flip counters to filename, replacing it atomically.
+func saveWear(filename string) error {
+	data, err := json.Marshal(snapshotWear())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("error saving dot wear: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving dot wear: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving dot wear: %v", err)
+	}
+	return nil
+}
+
+// saveWearPeriodically saves the flip counters every cfg.SaveInterval until
+// ctx is cancelled.
+func saveWearPeriodically(ctx context.Context, cfg WearConfig) {
+	ticker := simClock.NewTicker(cfg.SaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := saveWear(cfg.File); err != nil {
+				log.Error(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
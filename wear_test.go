@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWearPersists(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	filename := filepath.Join(t.TempDir(), "wear.json")
+
+	frame := newFrame(16, 8)
+	frame[1][2] = true
+	applyFrame(frame)
+	applyFrame(newFrame(16, 8))
+	if err := saveWear(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	initializeDisplay()
+	if err := loadWear(filename); err != nil {
+		t.Fatal(err)
+	}
+	applyFrame(frame)
+
+	report := snapshotWear()
+	if report.Flips[1][2] != 3 || report.Total != 3 || report.Max != 3 {
+		t.Errorf("Expected 3 flips on dot 1,2 after a restart, got %+v", report)
+	}
+}
+
+func TestWearCSVExport(t *testing.T) {
+	withConfig(t, Config{Columns: 8, Rows: 16, Address: 1})
+	frame := newFrame(16, 8)
+	frame[15][7] = true
+	applyFrame(frame)
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wear?format=csv", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 1+16*8 || lines[0] != "row,column,flips" || lines[len(lines)-1] != "15,7,1" {
+		t.Errorf("Unexpected CSV export (%d lines): %q ... %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+}
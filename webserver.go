@@ -2,16 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"embed"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"html/template"
+	"image"
+	"image/png"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
 )
 
+// assets holds the web UI's templates and static files, so the web server
+// works from any directory.
+//
+//go:embed templates static
+var assets embed.FS
+
 var (
 	templates    *template.Template
 	clients      = make(map[chan string]bool)
@@ -28,17 +46,115 @@ func init() {
 			}
 			return Items
 		},
-	}).ParseFiles(
+	}).ParseFS(assets,
 		"templates/layout.html",
 		"templates/display.html",
 	))
 }
 
-func runWebServer() {
+var (
+	router       *gin.Engine
+	webServer    *http.Server
+	webServerMux sync.Mutex
+)
+
+// runWebServer starts the web server and the periodic client updates, which
+// stop when ctx is cancelled.
+func runWebServer(ctx context.Context) error {
+	router = newRouter()
+	if err := listenWebServer(currentConfig().WebPort); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := simClock.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				updateClients()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// shutdownWebServer ends all event streams and shuts the web server down,
+// giving in-flight requests a few seconds to finish.
+func shutdownWebServer() {
+	closeClients()
+
+	webServerMux.Lock()
+	srv := webServer
+	webServer = nil
+	webServerMux.Unlock()
+	if srv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("Web server did not shut down cleanly: %v", err)
+		srv.Close()
+	}
+	log.Info("Web server stopped")
+}
+
+// listenWebServer starts serving the router on addr. If a server is already
+// running it is shut down once the new listener is bound, so a failed rebind
+// leaves the old server in place.
+func listenWebServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	serveWebListener(listener)
+	return nil
+}
+
+// serveWebListener serves the router on an already bound listener, replacing
+// any server that was running before.
+func serveWebListener(listener net.Listener) {
+	addr := listener.Addr().String()
+	srv := &http.Server{Handler: router}
+
+	webServerMux.Lock()
+	old := webServer
+	webServer = srv
+	webServerMux.Unlock()
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Web server on %s failed: %v", addr, err)
+		}
+	}()
+	log.Infof("Web server listening on %s", addr)
+
+	if old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// Event streams never go idle, so close whatever is left
+			// once the grace period is over.
+			if err := old.Shutdown(ctx); err != nil {
+				old.Close()
+			}
+		}()
+	}
+}
+
+func newRouter() *gin.Engine {
 	r := gin.Default()
 
 	// Serve static files
-	r.Static("/static", "./static")
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err)
+	}
+	r.StaticFS("/static", http.FS(static))
 
 	r.GET("/", func(c *gin.Context) {
 			display.mu.Lock()
@@ -46,10 +162,11 @@ func runWebServer() {
 
 			jsonData := pixelsToJSON(display.pixels)
 
+			cfg := currentConfig()
 			err := templates.ExecuteTemplate(c.Writer, "layout.html", gin.H{
 				"Pixels":   display.pixels,
-				"Rows":     config.Rows,
-				"Columns":  config.Columns,
+				"Rows":     cfg.Rows,
+				"Columns":  cfg.Columns,
 				"JSONData": jsonData,
 			})
 			if err != nil {
@@ -72,8 +189,10 @@ func runWebServer() {
 
 			defer func() {
 				clientsMutex.Lock()
-				delete(clients, clientChan)
-				close(clientChan)
+				if clients[clientChan] {
+					delete(clients, clientChan)
+					close(clientChan)
+				}
 				clientsMutex.Unlock()
 			}()
 
@@ -87,11 +206,12 @@ func runWebServer() {
 		})
 
 	r.GET("/packets", func(c *gin.Context) {
+		packets := recentPackets(0)
 		packetInfos := make([]struct {
 			Timestamp time.Time
 			Length    int
-		}, len(packetLog))
-		for i, p := range packetLog {
+		}, len(packets))
+		for i, p := range packets {
 			packetInfos[i] = struct {
 				Timestamp time.Time
 				Length    int
@@ -104,6 +224,10 @@ func runWebServer() {
 	})
 
 	r.GET("/display", func(c *gin.Context) {
+		if c.Query("format") == "ascii" {
+			c.String(http.StatusOK, frameToASCII(snapshotDisplay()))
+			return
+		}
 		display.mu.Lock()
 		defer display.mu.Unlock()
 		c.JSON(http.StatusOK, gin.H{
@@ -112,15 +236,40 @@ func runWebServer() {
 		})
 	})
 
-	go func() {
-			for range time.Tick(100 * time.Millisecond) {
-				updateClients()
-			}
-		}()
+	r.POST("/display", handleDisplayASCII)
+	r.GET("/display.png", handleDisplayPNG)
+	r.POST("/display/compare", handleDisplayCompare)
+	r.GET("/display/text", handleDisplayText)
 
-		if err := r.Run(config.WebPort); err != nil {
-			log.Fatalf("Failed to start web server: %v", err)
-		}
+	r.GET("/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"serial":   getSerialStatus(),
+			"protocol": getProtocolStatus(),
+		})
+	})
+
+	r.GET("/metrics", gin.WrapF(handleMetrics))
+
+	r.GET("/wear", handleWear)
+	r.GET("/power", func(c *gin.Context) {
+		frames, overBudget := recentPower()
+		c.JSON(http.StatusOK, gin.H{
+			"model":       currentConfig().Power,
+			"frames":      frames,
+			"over_budget": overBudget,
+		})
+	})
+
+	r.GET("/history", handleHistory)
+	r.GET("/history/frame", handleHistoryFrame)
+
+	r.POST("/image", handleImageUpload)
+	r.GET("/config", func(c *gin.Context) {
+		c.YAML(http.StatusOK, currentConfig())
+	})
+	r.POST("/config", handleConfigUpdate)
+
+	return r
 }
 
 func updateClients() {
@@ -129,11 +278,12 @@ func updateClients() {
 
 	jsonData := pixelsToJSON(display.pixels)
 
+	cfg := currentConfig()
 	var buf bytes.Buffer
 	err := templates.ExecuteTemplate(&buf, "display", gin.H{
 		"Pixels":  display.pixels,
-		"Rows":    config.Rows,
-		"Columns": config.Columns,
+		"Rows":    cfg.Rows,
+		"Columns": cfg.Columns,
 	})
 	if err != nil {
 		log.Errorf("Error executing template: %v", err)
@@ -142,11 +292,15 @@ func updateClients() {
 	displayHTML := buf.String()
 
 	updateData := struct {
-		HTML string `json:"html"`
-		JSON string `json:"json"`
+		HTML     string         `json:"html"`
+		JSON     string         `json:"json"`
+		Serial   SerialStatus   `json:"serial"`
+		Protocol ProtocolStatus `json:"protocol"`
 	}{
-		HTML: displayHTML,
-		JSON: jsonData,
+		HTML:     displayHTML,
+		JSON:     jsonData,
+		Serial:   getSerialStatus(),
+		Protocol: getProtocolStatus(),
 	}
 
 	updateJSON, err := json.Marshal(updateData)
@@ -155,12 +309,18 @@ func updateClients() {
 		return
 	}
 
+	broadcast(string(updateJSON))
+}
+
+// broadcast sends msg to every connected event stream, skipping clients
+// that are not ready for it.
+func broadcast(msg string) {
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
 
 	for clientChan := range clients {
 		select {
-		case clientChan <- string(updateJSON):
+		case clientChan <- msg:
 			log.Debug("Sent update to client")
 		default:
 			log.Debug("Client not ready, skipped update")
@@ -168,8 +328,28 @@ func updateClients() {
 	}
 }
 
-func notifyNewPacket() {
+// closeClients ends every open event stream.
+func closeClients() {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	for clientChan := range clients {
+		delete(clients, clientChan)
+		close(clientChan)
+	}
+}
+
+// notifyConfigChange tells web clients to reload so they pick up a new
+// display layout.
+func notifyConfigChange() {
+	broadcast(`{"reload":true}`)
+}
+
+// notifyNewPacket records the display in the history as shown at the given
+// time and updates web clients.
+func notifyNewPacket(at time.Time) {
 	log.Debug("New packet received, triggering client update")
+	recordDisplayHistory(at)
 	updateClients()
 }
 
@@ -193,3 +373,311 @@ func pixelsToJSON(pixels [][]bool) string {
 	}
 	return string(jsonBytes)
 }
+
+// handleImageUpload converts an uploaded image into a frame, shows it on the
+// simulated display and returns the matching Hanover packet. The packet is
+// returned as JSON (hex encoded) unless ?format=bin is given.
+func handleImageUpload(c *gin.Context) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing image upload"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	img, err := decodeImage(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	threshold, err := strconv.Atoi(c.DefaultPostForm("threshold", strconv.Itoa(defaultThreshold)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a number"})
+		return
+	}
+	cfg := currentConfig()
+	frame, err := imageToFrame(img, cfg.Columns, cfg.Rows, ImageOptions{
+		Dither:    c.DefaultPostForm("dither", ditherThreshold),
+		Threshold: threshold,
+		Invert:    c.PostForm("invert") != "",
+		Stretch:   c.PostForm("stretch") != "",
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	showUploadedFrame(c, frame, "image "+file.Filename)
+}
+
+// handleDisplayASCII shows ASCII art posted as the request body on the
+// simulated display and returns the matching packets, like an image upload.
+// Art smaller than the display is drawn in its top-left corner.
+func handleDisplayASCII(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfg := currentConfig()
+	frame, err := asciiToDisplayFrame(string(body), cfg.Rows, cfg.Columns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	showUploadedFrame(c, frame, "ASCII art")
+}
+
+// showUploadedFrame shows an uploaded frame on the simulated display and
+// returns the Hanover packets for it, as JSON (hex encoded) unless
+// ?format=bin is given.
+func showUploadedFrame(c *gin.Context, frame [][]bool, source string) {
+	packets, err := encodeSignPackets(frame)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	updatedPixels := applyFrame(frame)
+	log.Infof("Applied uploaded %s. Updated %d pixels.", source, updatedPixels)
+	notifyNewPacket(simClock.Now())
+
+	packet := bytes.Join(packets, nil)
+	if c.Query("format") == "bin" {
+		c.Header("Content-Disposition", "attachment; filename=packet.bin")
+		c.Data(http.StatusOK, "application/octet-stream", packet)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"updated": updatedPixels,
+		"packet":  hex.EncodeToString(packet),
+	})
+}
+
+// handleDisplayPNG renders the whole sign as a PNG, with each dot ?scale
+// pixels wide (8 by default).
+func handleDisplayPNG(c *gin.Context) {
+	scale, ok := imageScale(c)
+	if !ok {
+		return
+	}
+	writePNG(c, frameImage(snapshotDisplay(), scale))
+}
+
+// imageScale reads the ?scale of a rendered image, 8 by default. On a bad
+// value it responds with an error and returns false.
+func imageScale(c *gin.Context) (int, bool) {
+	scale := 8
+	if s := c.Query("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scale must be between 1 and 32"})
+			return 0, false
+		}
+		scale = n
+	}
+	return scale, true
+}
+
+func writePNG(c *gin.Context, img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// handleDisplayText reads the text on the display by matching it against the
+// rendering fonts, or only against ?font.
+func handleDisplayText(c *gin.Context) {
+	result, err := recognizeText(snapshotDisplay(), c.Query("font"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// handleDisplayCompare compares the display to an uploaded golden frame, a
+// PNG image or ASCII art, allowing ?tolerance dots to differ. It returns the
+// comparison as JSON, or with ?format=png an image of the difference.
+func handleDisplayCompare(c *gin.Context) {
+	tolerance, err := strconv.Atoi(c.DefaultQuery("tolerance", c.DefaultPostForm("tolerance", "0")))
+	if err != nil || tolerance < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance must be a number of dots"})
+		return
+	}
+	file, err := c.FormFile("golden")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing golden upload"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	cfg := currentConfig()
+	want, err := readGolden(f, cfg.Rows, cfg.Columns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("golden %s: %v", file.Filename, err)})
+		return
+	}
+
+	got := snapshotDisplay()
+	if c.Query("format") == "png" {
+		scale, ok := imageScale(c)
+		if !ok {
+			return
+		}
+		writePNG(c, diffImage(got, want, scale))
+		return
+	}
+	c.JSON(http.StatusOK, compareFrames(got, want, tolerance))
+}
+
+// handleWear exports the per-dot flip counters as JSON, or as CSV with one
+// row,column,flips line per dot when ?format=csv is given.
+func handleWear(c *gin.Context) {
+	report := snapshotWear()
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=dot_wear.csv")
+	c.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"row", "column", "flips"})
+	for row := range report.Flips {
+		for col, flips := range report.Flips[row] {
+			w.Write([]string{strconv.Itoa(row), strconv.Itoa(col), strconv.FormatUint(flips, 10)})
+		}
+	}
+	w.Flush()
+}
+
+// handleHistory describes the frame history for the timeline scrubber.
+func handleHistory(c *gin.Context) {
+	if frameHistory == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "count": 0})
+		return
+	}
+	count, first, last := frameHistory.span()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"count":   count,
+		"first":   first,
+		"last":    last,
+	})
+}
+
+// handleHistoryFrame returns a frame from the history, either the one
+// showing at ?at= (RFC 3339 or HH:MM[:SS] today) or the one at ?index=.
+func handleHistoryFrame(c *gin.Context) {
+	if frameHistory == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "frame history is disabled"})
+		return
+	}
+
+	var entry historyEntry
+	var index int
+	var ok bool
+	if at := c.Query("at"); at != "" {
+		t, err := parseHistoryTime(at, simClock.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		entry, index, ok = frameHistory.at(t)
+	} else {
+		var err error
+		if index, err = strconv.Atoi(c.Query("index")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at or index is required"})
+			return
+		}
+		entry, ok = frameHistory.index(index)
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no frame recorded at that time"})
+		return
+	}
+
+	frame := entry.frame()
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, "display", gin.H{
+		"Pixels":  frame,
+		"Rows":    entry.Rows,
+		"Columns": entry.Columns,
+	})
+	if err != nil {
+		log.Errorf("Error executing template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error executing template"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"index":     index,
+		"timestamp": entry.Timestamp,
+		"pixels":    frame,
+		"json":      pixelsToJSON(frame),
+		"html":      buf.String(),
+	})
+}
+
+// isLocalRequest reports whether r comes from this machine and, if it was
+// sent by a web page, from a page the simulator served. Checking the origin
+// stops other sites open in a local browser from posting to the simulator.
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handleConfigUpdate applies a new configuration. With an empty body the
+// configuration file is reloaded; otherwise the body is YAML (or JSON) whose
+// keys are applied on top of the current configuration.
+func handleConfigUpdate(c *gin.Context) {
+	if !currentConfig().RemoteConfig && !isLocalRequest(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "configuration changes are only accepted from localhost unless remote_config is set"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		err = reloadConfig()
+	} else {
+		cfg := currentConfig()
+		if err = yaml.UnmarshalStrict(body, &cfg); err == nil {
+			if err = cfg.validate(); err == nil {
+				err = applyConfig(cfg)
+			}
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.YAML(http.StatusOK, currentConfig())
+}